@@ -10,11 +10,34 @@ import (
 )
 
 type Config struct {
-	Input                InputConfig       `json:"Input" validate:"required"`
-	Converters           []ConverterConfig `json:"Converters" validate:"required"`
-	MaxProcessThreads    int               `json:"MaxProcessThreads" validate:"required,min=1"`
-	MaxPreProcessThreads int               `json:"MaxPreProcessThreads" validate:"min=1;gtefield=MaxProcessThreads"`
-	LogLevel             slog.Level        `json:"LogLevel" validate:"required"`
+	Input                InputConfig          `json:"Input" validate:"required"`
+	Converters           []ConverterConfig    `json:"Converters" validate:"required"`
+	MaxProcessThreads    int                  `json:"MaxProcessThreads" validate:"required,min=1"`
+	MaxPreProcessThreads int                  `json:"MaxPreProcessThreads" validate:"min=1;gtefield=MaxProcessThreads"`
+	LogLevel             slog.Level           `json:"LogLevel" validate:"required"`
+	Duplicates           *DuplicatesConfig    `json:"Duplicates"`
+	ContactSheets        []ContactSheetConfig `json:"ContactSheets" validate:"dive"`
+}
+
+type DuplicatesConfig struct {
+	Algorithm   string `json:"Algorithm" validate:"omitempty,oneof=dhash phash"`
+	MaxDistance int    `json:"MaxDistance" validate:"min=0,max=64"`
+	HashCsvPath string `json:"HashCsvPath" validate:"omitempty,filepath"`
+}
+
+type ContactSheetConfig struct {
+	FileName     string       `json:"FileName"`
+	Columns      int          `json:"Columns" validate:"required,min=1"`
+	MaxTiles     int          `json:"MaxTiles" validate:"min=0"`
+	TileSize     int          `json:"TileSize" validate:"required,min=1"`
+	TileMode     string       `json:"TileMode" validate:"omitempty,oneof=fill smart pad"`
+	Spacing      int          `json:"Spacing" validate:"min=0"`
+	Background   string       `json:"Background"`
+	Captions     bool         `json:"Captions"`
+	CaptionColor string       `json:"CaptionColor"`
+	FontPath     string       `json:"FontPath"`
+	Quality      int          `json:"Quality" validate:"required,min=1,max=100"`
+	Output       OutputConfig `json:"Output" validate:"required"`
 }
 
 type InputConfig struct {
@@ -22,6 +45,19 @@ type InputConfig struct {
 	KnownExtensions       []string           `json:"KnownExtensions" validate:"required,min=0,dive,min=1"`
 	CacheProcessed        bool               `json:"CacheProcessed"`
 	CacheProcessedCsvPath string             `json:"CacheProcessedCsvPath" validate:"filepath"`
+	FocusSidecarSuffix    string             `json:"FocusSidecarSuffix"`
+	Limits                InputLimitsConfig  `json:"Limits"`
+}
+
+// InputLimitsConfig protects from decompression bombs, refusing inputs over any of the limits before they are decoded.
+// Zero values mean no limit, and for animations MaxPixels covers all frames together. With Downscale, still inputs over
+// the pixel limits are decoded at a size within them instead, if their format supports it (WebP).
+type InputLimitsConfig struct {
+	MaxFileSize int64 `json:"MaxFileSize" validate:"min=0"`
+	MaxWidth    int   `json:"MaxWidth" validate:"min=0"`
+	MaxHeight   int   `json:"MaxHeight" validate:"min=0"`
+	MaxPixels   int64 `json:"MaxPixels" validate:"min=0"`
+	Downscale   bool  `json:"Downscale"`
 }
 
 type InputStorageConfig struct {
@@ -68,16 +104,22 @@ func (sc *InputStorageConfig) UnmarshalJSON(data []byte) error {
 }
 
 type ConverterConfig struct {
-	Type   string       `json:"Type" validate:"required,oneof=webp jpeg"`
-	Config any          `json:"Config" validate:"required"`
-	Output OutputConfig `json:"Output" validate:"required"`
+	Type         string             `json:"Type" validate:"required,oneof=webp jpeg avif png blurhash lqip palette"`
+	Config       any                `json:"Config" validate:"required"`
+	Metadata     MetadataConfig     `json:"Metadata"`
+	ColorProfile ColorProfileConfig `json:"ColorProfile"`
+	Overlay      *OverlayConfig     `json:"Overlay"`
+	Output       OutputConfig       `json:"Output" validate:"required"`
 }
 
 func (pc *ConverterConfig) UnmarshalJSON(data []byte) error {
 	var tmp struct {
-		Type   string          `json:"Type"`
-		Config json.RawMessage `json:"Config"`
-		Output OutputConfig    `json:"Output"`
+		Type         string             `json:"Type"`
+		Config       json.RawMessage    `json:"Config"`
+		Metadata     MetadataConfig     `json:"Metadata"`
+		ColorProfile ColorProfileConfig `json:"ColorProfile"`
+		Overlay      *OverlayConfig     `json:"Overlay"`
+		Output       OutputConfig       `json:"Output"`
 	}
 
 	if err := json.Unmarshal(data, &tmp); err != nil {
@@ -85,6 +127,9 @@ func (pc *ConverterConfig) UnmarshalJSON(data []byte) error {
 	}
 
 	pc.Type = tmp.Type
+	pc.Metadata = tmp.Metadata
+	pc.ColorProfile = tmp.ColorProfile
+	pc.Overlay = tmp.Overlay
 	pc.Output = tmp.Output
 
 	switch tmp.Type {
@@ -100,6 +145,36 @@ func (pc *ConverterConfig) UnmarshalJSON(data []byte) error {
 			return fmt.Errorf("unmarshal JpegConfig: %w", err)
 		}
 		pc.Config = &jpegConfig
+	case "avif":
+		var avifConfig AvifConfig
+		if err := json.Unmarshal(tmp.Config, &avifConfig); err != nil {
+			return fmt.Errorf("unmarshal AvifConfig: %w", err)
+		}
+		pc.Config = &avifConfig
+	case "png":
+		var pngConfig PngConfig
+		if err := json.Unmarshal(tmp.Config, &pngConfig); err != nil {
+			return fmt.Errorf("unmarshal PngConfig: %w", err)
+		}
+		pc.Config = &pngConfig
+	case "blurhash":
+		var blurhashConfig BlurhashConfig
+		if err := json.Unmarshal(tmp.Config, &blurhashConfig); err != nil {
+			return fmt.Errorf("unmarshal BlurhashConfig: %w", err)
+		}
+		pc.Config = &blurhashConfig
+	case "lqip":
+		var lqipConfig LqipConfig
+		if err := json.Unmarshal(tmp.Config, &lqipConfig); err != nil {
+			return fmt.Errorf("unmarshal LqipConfig: %w", err)
+		}
+		pc.Config = &lqipConfig
+	case "palette":
+		var paletteConfig PaletteConfig
+		if err := json.Unmarshal(tmp.Config, &paletteConfig); err != nil {
+			return fmt.Errorf("unmarshal PaletteConfig: %w", err)
+		}
+		pc.Config = &paletteConfig
 	default:
 		return fmt.Errorf("unsupported storage type: %s", tmp.Type)
 	}
@@ -108,19 +183,134 @@ func (pc *ConverterConfig) UnmarshalJSON(data []byte) error {
 }
 
 type WebpConfig struct {
-	Quality int        `json:"Quality" validate:"required,min=1,max=100"`
-	Size    SizeConfig `json:"Size"`
+	Quality           int                `json:"Quality" validate:"omitempty,min=1,max=100"`
+	MaxBytes          int                `json:"MaxBytes" validate:"min=0"`
+	MinQuality        int                `json:"MinQuality" validate:"omitempty,min=1,max=100"`
+	MaxQuality        int                `json:"MaxQuality" validate:"omitempty,min=1,max=100"`
+	AutoQuality       *AutoQualityConfig `json:"AutoQuality"`
+	Mode              string             `json:"Mode" validate:"omitempty,oneof=lossy lossless near-lossless"`
+	Preset            string             `json:"Preset" validate:"omitempty,oneof=default photo picture drawing icon text"`
+	Method            *int               `json:"Method" validate:"omitempty,min=0,max=6"`
+	NearLossless      *int               `json:"NearLossless" validate:"omitempty,min=0,max=100"`
+	AlphaQuality      *int               `json:"AlphaQuality" validate:"omitempty,min=0,max=100"`
+	SharpYuv          bool               `json:"SharpYuv"`
+	FilterStrength    *int               `json:"FilterStrength" validate:"omitempty,min=0,max=100"`
+	TargetSize        int                `json:"TargetSize" validate:"min=0"`
+	Animation         AnimationConfig    `json:"Animation"`
+	IgnoreOrientation bool               `json:"IgnoreOrientation"`
+	Size              SizeConfig         `json:"Size"`
 }
 
 type JpegConfig struct {
-	ExtensionName string     `json:"ExtensionName" validate:"alpha"`
-	Quality       int        `json:"Quality" validate:"required,min=1,max=100"`
-	Size          SizeConfig `json:"Size"`
+	ExtensionName     string             `json:"ExtensionName" validate:"alpha"`
+	Quality           int                `json:"Quality" validate:"omitempty,min=1,max=100"`
+	MaxBytes          int                `json:"MaxBytes" validate:"min=0"`
+	MinQuality        int                `json:"MinQuality" validate:"omitempty,min=1,max=100"`
+	MaxQuality        int                `json:"MaxQuality" validate:"omitempty,min=1,max=100"`
+	AutoQuality       *AutoQualityConfig `json:"AutoQuality"`
+	Animation         AnimationConfig    `json:"Animation"`
+	IgnoreOrientation bool               `json:"IgnoreOrientation"`
+	Size              SizeConfig         `json:"Size"`
+}
+
+type AutoQualityConfig struct {
+	MinScore float64 `json:"MinScore" validate:"required,gt=0,lte=1"`
+}
+
+// AvifConfig outputs 4:2:0 without alpha, so transparent inputs are flattened onto Background, white by default.
+type AvifConfig struct {
+	Quality           int             `json:"Quality" validate:"required,min=1,max=100"`
+	Speed             int             `json:"Speed" validate:"min=0,max=8"`
+	Background        string          `json:"Background"`
+	Animation         AnimationConfig `json:"Animation"`
+	IgnoreOrientation bool            `json:"IgnoreOrientation"`
+	Size              SizeConfig      `json:"Size"`
+}
+
+type PngConfig struct {
+	CompressionLevel  string            `json:"CompressionLevel" validate:"omitempty,oneof=Default NoCompression BestSpeed BestCompression"`
+	Palette           *PngPaletteConfig `json:"Palette"`
+	Animation         AnimationConfig   `json:"Animation"`
+	IgnoreOrientation bool              `json:"IgnoreOrientation"`
+	Size              SizeConfig        `json:"Size"`
+}
+
+type BlurhashConfig struct {
+	ComponentsX       int             `json:"ComponentsX" validate:"omitempty,min=1,max=9"`
+	ComponentsY       int             `json:"ComponentsY" validate:"omitempty,min=1,max=9"`
+	Animation         AnimationConfig `json:"Animation"`
+	IgnoreOrientation bool            `json:"IgnoreOrientation"`
+}
+
+type LqipConfig struct {
+	Format            string          `json:"Format" validate:"omitempty,oneof=webp jpeg"`
+	Quality           int             `json:"Quality" validate:"required,min=1,max=100"`
+	MaxBytes          int             `json:"MaxBytes" validate:"min=0"`
+	Animation         AnimationConfig `json:"Animation"`
+	IgnoreOrientation bool            `json:"IgnoreOrientation"`
+	Size              SizeConfig      `json:"Size"`
+}
+
+type PaletteConfig struct {
+	Colors            int             `json:"Colors" validate:"omitempty,min=1,max=32"`
+	Animation         AnimationConfig `json:"Animation"`
+	IgnoreOrientation bool            `json:"IgnoreOrientation"`
+}
+
+type PngPaletteConfig struct {
+	MaxColors int  `json:"MaxColors" validate:"required,min=2,max=256"`
+	Dithering bool `json:"Dithering"`
+}
+
+type MetadataConfig struct {
+	Mode string   `json:"Mode" validate:"omitempty,oneof=strip keep whitelist"`
+	Tags []string `json:"Tags"`
+}
+
+type ColorProfileConfig struct {
+	Target string `json:"Target" validate:"omitempty,oneof=srgb display-p3 adobe-rgb"`
+	Embed  bool   `json:"Embed"`
+}
+
+type OverlayConfig struct {
+	Image    *OverlayImageConfig `json:"Image"`
+	Text     *OverlayTextConfig  `json:"Text"`
+	Position string              `json:"Position" validate:"omitempty,oneof=top-left top-right bottom-left bottom-right center"`
+	Margin   float64             `json:"Margin" validate:"min=0,max=0.5"`
+	Opacity  float64             `json:"Opacity" validate:"min=0,max=1"`
+	Scale    float64             `json:"Scale" validate:"gt=0,max=1"`
+}
+
+type OverlayImageConfig struct {
+	Storage InputStorageConfig `json:"Storage" validate:"required"`
+	Path    string             `json:"Path" validate:"required"`
+}
+
+type OverlayTextConfig struct {
+	Template string `json:"Template" validate:"required"`
+	FontPath string `json:"FontPath" validate:"omitempty,filepath"`
+	Color    string `json:"Color"`
+}
+
+type AnimationConfig struct {
+	Mode  string `json:"Mode" validate:"omitempty,oneof=keep still"`
+	Frame int    `json:"Frame" validate:"min=0"`
 }
 
 type SizeConfig struct {
-	MaxWidth  int `json:"MaxWidth"`
-	MaxHeight int `json:"MaxHeight"`
+	Mode        string         `json:"Mode" validate:"omitempty,oneof=fit fill pad smart"`
+	MaxWidth    int            `json:"MaxWidth"`
+	MaxHeight   int            `json:"MaxHeight"`
+	Background  string         `json:"Background"`
+	Resampler   string         `json:"Resampler" validate:"omitempty,oneof=NearestNeighbor ApproxBiLinear BiLinear CatmullRom Lanczos3"`
+	LinearLight bool           `json:"LinearLight"`
+	Sharpen     *SharpenConfig `json:"Sharpen"`
+}
+
+type SharpenConfig struct {
+	Radius    float64 `json:"Radius" validate:"gt=0"`
+	Amount    float64 `json:"Amount" validate:"gt=0"`
+	Threshold int     `json:"Threshold" validate:"min=0,max=255"`
 }
 
 type OutputStorageConfig struct {
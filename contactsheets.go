@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/converter"
+)
+
+// renderContactSheets groups scanned inputs by their directory, and renders every configured contact sheet for each of these albums.
+func renderContactSheets(cfg *config.Config, inputClient input.InputClient, sheets []*converter.ContactSheet, files []string, logger *slog.Logger) {
+	albums := make(map[string][]string)
+	for _, file := range files {
+		album := albumOf(file)
+		albums[album] = append(albums[album], file)
+	}
+
+	semaphore := make(chan struct{}, cfg.MaxProcessThreads)
+	var wg sync.WaitGroup
+	for _, album := range slices.Sorted(maps.Keys(albums)) {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(album string, names []string) {
+			defer func() { <-semaphore; wg.Done() }()
+			albumLogger := logger.With(slog.String("album", album))
+
+			slices.Sort(names)
+			inputs := make([]converter.ContactSheetInput, 0, len(names))
+			for _, name := range names {
+				inputMetadata, err := readInputMetadata(cfg, inputClient, name, albumLogger)
+				if err != nil {
+					albumLogger.Warn("fail to read metadata of (supposedly existing) input file", slog.String("input_path", name), slog.String("error", err.Error()))
+					continue
+				}
+				inputs = append(inputs, converter.ContactSheetInput{Name: name, Metadata: inputMetadata})
+			}
+			if len(inputs) == 0 {
+				return
+			}
+			albumMetadata := newAlbumMetadata(album, inputs)
+
+			sheetsToRender := []int{}
+			for i, sheet := range sheets {
+				outputName := sheet.DeductOutputPath(album)
+				sheetLogger := albumLogger.With(slog.String("output_path", outputName), slog.Int("sheet_index", i))
+
+				switch cfg.ContactSheets[i].Output.RewriteOn {
+				case "Never":
+					if !sheet.IsMissing(outputName) {
+						sheetLogger.Info("skip already existing contact sheet")
+						continue
+					}
+				case "UnequalHashInCache":
+					if !sheet.IsMissing(outputName) {
+						outputMetadata, err := sheet.ReadMetadata(outputName)
+						if err != nil {
+							sheetLogger.Warn("fail to read metadata of (supposedly existing) contact sheet", slog.String("error", err.Error()))
+							continue
+						}
+						if outputMetadata.HashOriginal == albumMetadata.Hash {
+							sheetLogger.Info("skip already rendered contact sheet (based on equal hash)", slog.String("album_hash", albumMetadata.Hash))
+							continue
+						}
+					}
+				case "Always":
+				}
+				sheetsToRender = append(sheetsToRender, i)
+			}
+
+			// every input is downloaded and decoded once, and only its tiles are kept for all sheets
+			tiles := make([][]image.Image, len(sheets))
+			tileCount := 0
+			for _, i := range sheetsToRender {
+				tiles[i] = make([]image.Image, sheets[i].TileCount(len(inputs)))
+				tileCount = max(tileCount, len(tiles[i]))
+			}
+			for j, in := range inputs[:tileCount] {
+				source, err := readSource(cfg, inputClient, in)
+				if err != nil {
+					albumLogger.Warn("skip contact sheet tile", slog.String("input_path", in.Name), slog.String("error", err.Error()))
+					continue
+				}
+				for _, i := range sheetsToRender {
+					if j >= len(tiles[i]) {
+						continue
+					}
+					if tiles[i][j], err = sheets[i].Tile(source); err != nil {
+						albumLogger.Warn("skip contact sheet tile", slog.String("input_path", in.Name), slog.Int("sheet_index", i), slog.String("error", err.Error()))
+					}
+				}
+			}
+
+			for _, i := range sheetsToRender {
+				outputName := sheets[i].DeductOutputPath(album)
+				sheetLogger := albumLogger.With(slog.String("output_path", outputName), slog.Int("sheet_index", i))
+				if err := sheets[i].Process(albumMetadata, inputs, tiles[i], outputName); err != nil {
+					sheetLogger.Warn("fail to render contact sheet", slog.String("error", err.Error()))
+					continue
+				}
+				sheetLogger.Info("successfully rendered contact sheet", slog.Int("input_count", len(inputs)))
+			}
+		}(album, albums[album])
+	}
+	wg.Wait()
+}
+
+// albumOf returns the album of an input, which is its directory, or empty for inputs at the root.
+func albumOf(inputName string) string {
+	if album := path.Dir(inputName); album != "." {
+		return album
+	}
+	return ""
+}
+
+// readSource downloads an input for its contact sheet tiles.
+func readSource(cfg *config.Config, inputClient input.InputClient, in converter.ContactSheetInput) (*converter.Source, error) {
+	if err := converter.CheckFileSize(cfg.Input.Limits, in.Metadata.Size); err != nil {
+		return nil, err
+	}
+	reader, err := inputClient.GetReader(in.Name)
+	if err != nil {
+		return nil, fmt.Errorf("get reader: %w", err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read content: %w", err)
+	}
+	return converter.NewSource(in.Metadata, content, cfg.Input.Limits), nil
+}
+
+// newAlbumMetadata describes an album as a single input, hashed over the names and hashes of its inputs,
+// so its contact sheets are rendered again whenever an input is added, removed or changed.
+func newAlbumMetadata(album string, inputs []converter.ContactSheetInput) *input.MetadataStruct {
+	albumMetadata := &input.MetadataStruct{Name: album, StorageType: inputs[0].Metadata.StorageType, Misc: map[string]string{}}
+	hash := sha1.New()
+	for _, in := range inputs {
+		fmt.Fprintf(hash, "%s:%s\n", in.Name, in.Metadata.Hash)
+		if in.Metadata.FocusHash != "" {
+			fmt.Fprintf(hash, "%s:focus:%s\n", in.Name, in.Metadata.FocusHash)
+		}
+		if albumMetadata.FirstCreated.IsZero() || in.Metadata.FirstCreated.Before(albumMetadata.FirstCreated) {
+			albumMetadata.FirstCreated = in.Metadata.FirstCreated
+		}
+		if in.Metadata.LastModified.After(albumMetadata.LastModified) {
+			albumMetadata.LastModified = in.Metadata.LastModified
+		}
+		albumMetadata.Size += in.Metadata.Size
+	}
+	albumMetadata.Hash = hex.EncodeToString(hash.Sum(nil))
+	return albumMetadata
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/bits"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/converter"
+)
+
+// perceptualHashRecord is the perceptual hash of an input, along with the content hash it was computed for.
+type perceptualHashRecord struct {
+	inputHash string
+	hash      uint64
+}
+
+// perceptualHashCache keeps perceptual hashes by input name, so unchanged inputs are not downloaded again for reports.
+type perceptualHashCache struct {
+	mutex   sync.Mutex
+	records map[string]perceptualHashRecord
+}
+
+type duplicateGroup struct {
+	Inputs []string `json:"Inputs"`
+}
+
+// loadPerceptualHashCache reads a '{input-name},{input-hash},{perceptual-hash}' CSV file. A missing file gives an empty cache.
+func loadPerceptualHashCache(path string) (*perceptualHashCache, error) {
+	cache := &perceptualHashCache{records: make(map[string]perceptualHashRecord)}
+	if path == "" {
+		return cache, nil
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	csvReader := csv.NewReader(file)
+	for {
+		rec, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) != 3 {
+			return nil, fmt.Errorf("incorrect format: expected '{input-name},{input-hash},{perceptual-hash}', got %d fields", len(rec))
+		}
+		hash, err := strconv.ParseUint(rec[2], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse perceptual hash of %s: %w", rec[0], err)
+		}
+		cache.records[rec[0]] = perceptualHashRecord{rec[1], hash}
+	}
+	return cache, nil
+}
+
+func (c *perceptualHashCache) get(name, inputHash string) (uint64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	rec, ok := c.records[name]
+	return rec.hash, ok && rec.inputHash == inputHash
+}
+
+func (c *perceptualHashCache) set(name, inputHash string, hash uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.records[name] = perceptualHashRecord{inputHash, hash}
+}
+
+func (c *perceptualHashCache) save(path string) error {
+	file, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	csvWriter := csv.NewWriter(file)
+	for name, rec := range c.records {
+		if err := csvWriter.Write([]string{name, rec.inputHash, strconv.FormatUint(rec.hash, 16)}); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// hashInput computes the perceptual hash of an already downloaded input and stores it in the cache,
+// unless the cache already has a hash for its content.
+func (c *perceptualHashCache) hashInput(cfg *config.DuplicatesConfig, inputName string, source *converter.Source) (uint64, error) {
+	if hash, ok := c.get(inputName, source.Metadata.Hash); ok {
+		return hash, nil
+	}
+	hash, err := converter.PerceptualHash(source, cfg.Algorithm)
+	if err != nil {
+		return 0, err
+	}
+	c.set(inputName, source.Metadata.Hash, hash)
+	return hash, nil
+}
+
+// hashFile returns the perceptual hash of an input, downloading it only when the cache has no hash for its content.
+func (c *perceptualHashCache) hashFile(cfg *config.Config, inputClient input.InputClient, inputName string, inputMetadata *input.MetadataStruct) (uint64, error) {
+	if hash, ok := c.get(inputName, inputMetadata.Hash); ok {
+		return hash, nil
+	}
+	if err := converter.CheckFileSize(cfg.Input.Limits, inputMetadata.Size); err != nil {
+		return 0, err
+	}
+
+	reader, err := inputClient.GetReader(inputName)
+	if err != nil {
+		return 0, fmt.Errorf("get reader: %w", err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, fmt.Errorf("read content: %w", err)
+	}
+
+	return c.hashInput(cfg.Duplicates, inputName, converter.NewSource(inputMetadata, content, cfg.Input.Limits))
+}
+
+// reportDuplicates hashes every scanned input and prints groups of inputs within MaxDistance bits of each other as JSON.
+func reportDuplicates(cfg *config.Config, inputClient input.InputClient, files []string, cache *perceptualHashCache, logger *slog.Logger) error {
+	hashes := make([]uint64, len(files))
+	hashed := make([]bool, len(files))
+	semaphore := make(chan struct{}, cfg.MaxProcessThreads)
+	var wg sync.WaitGroup
+
+	for i, file := range files {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(index int, inputName string) {
+			defer func() { <-semaphore; wg.Done() }()
+			fileLogger := logger.With(slog.String("input_path", inputName), slog.Int("file_index", index))
+
+			inputMetadata, err := readInputMetadata(cfg, inputClient, inputName, fileLogger)
+			if err != nil {
+				fileLogger.Warn("fail to read metadata of (supposedly existing) input file", slog.String("error", err.Error()))
+				return
+			}
+			hash, err := cache.hashFile(cfg, inputClient, inputName, inputMetadata)
+			if err != nil {
+				fileLogger.Warn("fail to compute perceptual hash of input file", slog.String("error", err.Error()))
+				return
+			}
+			hashes[index], hashed[index] = hash, true
+		}(i, file)
+	}
+	wg.Wait()
+
+	// inputs are grouped transitively: a chain of burst shots ends up in one group even if its ends drifted apart
+	parents := make([]int, len(files))
+	for i := range parents {
+		parents[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		if parents[i] != i {
+			parents[i] = root(parents[i])
+		}
+		return parents[i]
+	}
+	for i := range files {
+		for j := i + 1; j < len(files); j++ {
+			if hashed[i] && hashed[j] && bits.OnesCount64(hashes[i]^hashes[j]) <= cfg.Duplicates.MaxDistance {
+				parents[root(j)] = root(i)
+			}
+		}
+	}
+
+	groupIndexes := make(map[int]int)
+	groups := []duplicateGroup{}
+	for i, file := range files {
+		if !hashed[i] {
+			continue
+		}
+		r := root(i)
+		if index, ok := groupIndexes[r]; ok {
+			groups[index].Inputs = append(groups[index].Inputs, file)
+		} else {
+			groupIndexes[r] = len(groups)
+			groups = append(groups, duplicateGroup{Inputs: []string{file}})
+		}
+	}
+	groups = slices.DeleteFunc(groups, func(g duplicateGroup) bool { return len(g.Inputs) < 2 })
+	logger.Info("found duplicate groups", slog.Int("group_count", len(groups)))
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(groups)
+}
+
+func savePerceptualHashCache(cfg *config.Config, cache *perceptualHashCache, logger *slog.Logger) {
+	if cache == nil || cfg.Duplicates.HashCsvPath == "" {
+		return
+	}
+	logger.Info("writing perceptual hash cache file")
+	if err := cache.save(cfg.Duplicates.HashCsvPath); err != nil {
+		logger.Error("error writing perceptual hash cache into file", slog.String("error", err.Error()))
+	}
+}
@@ -4,6 +4,7 @@ go 1.26.0
 
 require (
 	github.com/Backblaze/blazer v0.7.2
+	github.com/Kagami/go-avif v0.1.0
 	github.com/go-playground/validator/v10 v10.30.1
 	github.com/kolesa-team/go-webp v1.0.5
 	golang.org/x/image v0.38.0
@@ -0,0 +1,48 @@
+package input
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// FocalPoint is the point crops are centered on, relative to the upright image: (0, 0) is the top-left corner
+// and (1, 1) the bottom-right one.
+type FocalPoint struct {
+	X float64 `json:"X"`
+	Y float64 `json:"Y"`
+}
+
+// ReadFocusSidecar looks for a sidecar named after the input with the given suffix (e.g. "photo.jpg.focus.json")
+// and sets the focal point of metadata from it. The hash of the sidecar is kept as FocusHash next to the hash
+// of the input, so outputs get regenerated when the focal point changes. A missing sidecar is not an error.
+func ReadFocusSidecar(client InputClient, path string, suffix string, metadata *MetadataStruct) error {
+	reader, err := client.GetReader(path + suffix)
+	if err != nil {
+		slog.Debug("no focus sidecar found", slog.String("path", path+suffix), slog.String("error", err.Error()))
+		return nil
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		slog.Debug("no focus sidecar found", slog.String("path", path+suffix), slog.String("error", err.Error()))
+		return nil
+	}
+
+	var focalPoint FocalPoint
+	if err := json.Unmarshal(data, &focalPoint); err != nil {
+		return fmt.Errorf("unmarshal focus sidecar: %w", err)
+	}
+	if focalPoint.X < 0 || focalPoint.X > 1 || focalPoint.Y < 0 || focalPoint.Y > 1 {
+		return fmt.Errorf("focal point (%g, %g) is out of the 0..1 range", focalPoint.X, focalPoint.Y)
+	}
+
+	sum := sha1.Sum(data)
+	metadata.FocalPoint = &focalPoint
+	metadata.FocusHash = hex.EncodeToString(sum[:])
+	return nil
+}
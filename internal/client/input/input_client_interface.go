@@ -23,6 +23,9 @@ type MetadataStruct struct {
 	LastModified time.Time
 	Size         int64
 	Misc         map[string]string
+	FocalPoint   *FocalPoint
+	// FocusHash is the hash of the focus sidecar the focal point was read from, if any
+	FocusHash string
 }
 
 var NewInputClientMap = map[string]func(cfg *config.InputConfig) (InputClient, error){
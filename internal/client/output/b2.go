@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 
 	"github.com/Backblaze/blazer/b2"
 	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
@@ -39,14 +40,20 @@ func NewB2OutputClient(cfg *config.OutputConfig) (OutputClient, error) {
 	return &B2OutputClient{b2cl: b2cl, bucket: bucket, prefix: b2cfg.Prefix}, nil
 }
 
-func (c *B2OutputClient) GetWriter(path string, inputMetadata *input.MetadataStruct, outputContentType string) (io.WriteCloser, error) {
+func (c *B2OutputClient) GetWriter(path string, inputMetadata *input.MetadataStruct, outputContentType string, misc map[string]string) (io.WriteCloser, error) {
 	obj := c.bucket.Object(c.prefix + path)
 	if obj == nil {
 		return nil, fmt.Errorf("failed to reference object in B2 bucket")
 	}
 
-	attrs := &b2.Attrs{Info: make(map[string]string)}
+	attrs := &b2.Attrs{Info: maps.Clone(misc)}
+	if attrs.Info == nil {
+		attrs.Info = make(map[string]string)
+	}
 	attrs.Info["sha1-original"] = inputMetadata.Hash
+	if inputMetadata.FocusHash != "" {
+		attrs.Info["sha1-focus"] = inputMetadata.FocusHash
+	}
 	attrs.ContentType = outputContentType
 
 	return obj.NewWriter(context.Background(), b2.WithAttrsOption(attrs)), nil
@@ -64,15 +71,16 @@ func (c *B2OutputClient) ReadMetadata(path string) (*MetadataStruct, error) {
 	}
 
 	metadata := MetadataStruct{
-		Name:         attrs.Name,
-		StorageType:  "b2",
-		Hash:         attrs.SHA1,
-		HashOriginal: attrs.Info["sha1-original"],
-		ContentType:  attrs.ContentType,
-		FirstCreated: attrs.UploadTimestamp,
-		LastModified: attrs.LastModified,
-		Misc:         attrs.Info,
-		Size:         attrs.Size,
+		Name:              attrs.Name,
+		StorageType:       "b2",
+		Hash:              attrs.SHA1,
+		HashOriginal:      attrs.Info["sha1-original"],
+		FocusHashOriginal: attrs.Info["sha1-focus"],
+		ContentType:       attrs.ContentType,
+		FirstCreated:      attrs.UploadTimestamp,
+		LastModified:      attrs.LastModified,
+		Misc:              attrs.Info,
+		Size:              attrs.Size,
 	}
 
 	switch attrs.Status {
@@ -44,7 +44,7 @@ func NewLocalUnixOutputClient(cfg *config.OutputConfig) (OutputClient, error) {
 	return &LocalUnixOutputClient{localCfg.Path, uint32(fpm), uint32(dpm), localCfg.AttributesImplementation}, nil
 }
 
-func (c *LocalUnixOutputClient) GetWriter(path string, inputMetadata *input.MetadataStruct, _ string) (io.WriteCloser, error) {
+func (c *LocalUnixOutputClient) GetWriter(path string, inputMetadata *input.MetadataStruct, _ string, misc map[string]string) (io.WriteCloser, error) {
 	pathSegments := strings.Split(path, "/")
 	dirpath := strings.Join(pathSegments[0:len(pathSegments)-1], "/")
 	if err := os.MkdirAll(c.path+dirpath, os.FileMode(c.dirMode)); err != nil {
@@ -68,6 +68,19 @@ func (c *LocalUnixOutputClient) GetWriter(path string, inputMetadata *input.Meta
 		if err := unix.Setxattr(c.path+path, "user.originalfile.mddate", []byte(strconv.FormatInt(inputMetadata.LastModified.Unix(), 16)), 0); err != nil {
 			return nil, fmt.Errorf("fail to write user.originalfile.mddate xattribute: %w", err)
 		}
+		// a stale focus hash would get the output rewritten on every run, so it is removed along with the sidecar
+		if inputMetadata.FocusHash != "" {
+			if err := unix.Setxattr(c.path+path, "user.originalfile.focus", []byte(inputMetadata.FocusHash), 0); err != nil {
+				return nil, fmt.Errorf("fail to write user.originalfile.focus xattribute: %w", err)
+			}
+		} else if err := unix.Removexattr(c.path+path, "user.originalfile.focus"); err != nil && err != unix.ENOATTR {
+			return nil, fmt.Errorf("fail to remove user.originalfile.focus xattribute: %w", err)
+		}
+		for key, value := range misc {
+			if err := unix.Setxattr(c.path+path, "user.output."+key, []byte(value), 0); err != nil {
+				return nil, fmt.Errorf("fail to write user.output.%s xattribute: %w", key, err)
+			}
+		}
 	case "none":
 	default:
 		return nil, fmt.Errorf("unknown attributes implementation: %s", c.attrMode)
@@ -94,6 +107,8 @@ func (c *LocalUnixOutputClient) ReadMetadata(path string) (*MetadataStruct, erro
 	creationTime := time.Unix(stat_t.Ctimespec.Sec, stat_t.Ctimespec.Nsec)
 
 	mddateOriginal := make([]byte, 0)
+	focusOriginal := make([]byte, 0)
+	misc := map[string]string{}
 	switch c.attrMode {
 	case "xattr":
 		sz, err := unix.Getxattr(c.path+path, "user.originalfile.mddate", nil)
@@ -105,24 +120,64 @@ func (c *LocalUnixOutputClient) ReadMetadata(path string) (*MetadataStruct, erro
 		if _, err = unix.Getxattr(c.path+path, "user.originalfile.mddate", mddateOriginal); err != nil {
 			return nil, fmt.Errorf("fail to get user.originalfile.mddate attribute: %w", err)
 		}
+		if sz, err := unix.Getxattr(c.path+path, "user.originalfile.focus", nil); err == nil {
+			focusOriginal = make([]byte, sz)
+			if _, err = unix.Getxattr(c.path+path, "user.originalfile.focus", focusOriginal); err != nil {
+				return nil, fmt.Errorf("fail to get user.originalfile.focus attribute: %w", err)
+			}
+		}
+		if misc, err = readOutputXattrs(c.path + path); err != nil {
+			return nil, err
+		}
 	case "none":
 	default:
 		return nil, fmt.Errorf("unknown attributes implementation: %s", c.attrMode)
 	}
 
 	return &MetadataStruct{
-		Name:         fileInfo.Name(),
-		StorageType:  "local-unix",
-		Hash:         strconv.FormatInt(fileInfo.ModTime().Unix(), 16),
-		HashOriginal: string(mddateOriginal),
-		ContentType:  mime.TypeByExtension("." + nodeExt),
-		FirstCreated: creationTime,
-		LastModified: fileInfo.ModTime(),
-		Size:         fileInfo.Size(),
-		Misc:         map[string]string{},
+		Name:              fileInfo.Name(),
+		StorageType:       "local-unix",
+		Hash:              strconv.FormatInt(fileInfo.ModTime().Unix(), 16),
+		HashOriginal:      string(mddateOriginal),
+		ContentType:       mime.TypeByExtension("." + nodeExt),
+		FirstCreated:      creationTime,
+		LastModified:      fileInfo.ModTime(),
+		Size:              fileInfo.Size(),
+		Misc:              misc,
+		FocusHashOriginal: string(focusOriginal),
 	}, nil
 }
 
+// readOutputXattrs reads the user.output.* attributes written from the misc metadata of an output.
+func readOutputXattrs(path string) (map[string]string, error) {
+	misc := map[string]string{}
+	sz, err := unix.Listxattr(path, nil)
+	if err != nil || sz == 0 {
+		return misc, nil
+	}
+	names := make([]byte, sz)
+	if sz, err = unix.Listxattr(path, names); err != nil {
+		return nil, fmt.Errorf("fail to list attributes: %w", err)
+	}
+
+	for _, name := range strings.Split(string(names[:sz]), "\x00") {
+		key, ok := strings.CutPrefix(name, "user.output.")
+		if !ok {
+			continue
+		}
+		sz, err := unix.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("fail to get size of %s attribute: %w", name, err)
+		}
+		value := make([]byte, sz)
+		if _, err = unix.Getxattr(path, name, value); err != nil {
+			return nil, fmt.Errorf("fail to get %s attribute: %w", name, err)
+		}
+		misc[key] = string(value)
+	}
+	return misc, nil
+}
+
 func (c *LocalUnixOutputClient) IsMissing(path string) bool {
 	_, err := os.Stat(c.path + path)
 	return err != nil
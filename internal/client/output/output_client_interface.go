@@ -9,7 +9,9 @@ import (
 )
 
 type OutputClient interface {
-	GetWriter(path string, inputMetadata *input.MetadataStruct, outputContentType string) (io.WriteCloser, error)
+	// GetWriter opens an output for writing. misc is stored along with the output, and is returned as Misc of its metadata
+	// by storages that support it.
+	GetWriter(path string, inputMetadata *input.MetadataStruct, outputContentType string, misc map[string]string) (io.WriteCloser, error)
 	ReadMetadata(path string) (*MetadataStruct, error)
 	IsMissing(path string) bool
 }
@@ -24,6 +26,8 @@ type MetadataStruct struct {
 	LastModified time.Time
 	Size         int64
 	Misc         map[string]string
+	// FocusHashOriginal is the FocusHash of the input the output was made from
+	FocusHashOriginal string
 }
 
 var NewOutputClientMap = map[string]func(cfg *config.OutputConfig) (OutputClient, error){
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -44,7 +45,7 @@ func NewS3OutputClient(cfg *config.OutputConfig) (OutputClient, error) {
 	}, nil
 }
 
-func (c *S3OutputClient) GetWriter(path string, inputMetadata *input.MetadataStruct, outputContentType string) (io.WriteCloser, error) {
+func (c *S3OutputClient) GetWriter(path string, inputMetadata *input.MetadataStruct, outputContentType string, misc map[string]string) (io.WriteCloser, error) {
 	key := c.prefix + path
 
 	return &s3WriteCloser{
@@ -53,6 +54,8 @@ func (c *S3OutputClient) GetWriter(path string, inputMetadata *input.MetadataStr
 		s3cl:              c.s3cl,
 		contentType:       outputContentType,
 		hashOriginal:      inputMetadata.Hash,
+		focusHashOriginal: inputMetadata.FocusHash,
+		misc:              misc,
 		buf:               &bytes.Buffer{},
 	}, nil
 }
@@ -69,12 +72,13 @@ func (c *S3OutputClient) ReadMetadata(path string) (*MetadataStruct, error) {
 	}
 
 	metadata := MetadataStruct{
-		Name:         key,
-		StorageType:  "s3",
-		Hash:         strings.Trim(aws.ToString(head.ETag), "\""),
-		HashOriginal: head.Metadata["sha1-original"],
-		ContentType:  aws.ToString(head.ContentType),
-		Misc:         head.Metadata,
+		Name:              key,
+		StorageType:       "s3",
+		Hash:              strings.Trim(aws.ToString(head.ETag), "\""),
+		HashOriginal:      head.Metadata["sha1-original"],
+		FocusHashOriginal: head.Metadata["sha1-focus"],
+		ContentType:       aws.ToString(head.ContentType),
+		Misc:              head.Metadata,
 	}
 
 	if head.ContentLength != nil {
@@ -111,12 +115,14 @@ func (c *S3OutputClient) IsMissing(path string) bool {
 
 // s3WriteCloser buffers writes and uploads to S3 on Close.
 type s3WriteCloser struct {
-	key          string
-	bucketName   string
-	s3cl         *s3.Client
-	contentType  string
-	hashOriginal string
-	buf          *bytes.Buffer
+	key               string
+	bucketName        string
+	s3cl              *s3.Client
+	contentType       string
+	hashOriginal      string
+	focusHashOriginal string
+	misc              map[string]string
+	buf               *bytes.Buffer
 }
 
 func (w *s3WriteCloser) Write(p []byte) (int, error) {
@@ -124,14 +130,21 @@ func (w *s3WriteCloser) Write(p []byte) (int, error) {
 }
 
 func (w *s3WriteCloser) Close() error {
+	metadata := maps.Clone(w.misc)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata["sha1-original"] = w.hashOriginal
+	if w.focusHashOriginal != "" {
+		metadata["sha1-focus"] = w.focusHashOriginal
+	}
+
 	_, err := w.s3cl.PutObject(context.Background(), &s3.PutObjectInput{
 		Bucket:      aws.String(w.bucketName),
 		Key:         aws.String(w.key),
 		Body:        bytes.NewReader(w.buf.Bytes()),
 		ContentType: aws.String(w.contentType),
-		Metadata: map[string]string{
-			"sha1-original": w.hashOriginal,
-		},
+		Metadata:    metadata,
 	})
 	if err != nil {
 		return fmt.Errorf("put S3 object %s: %w", w.key, err)
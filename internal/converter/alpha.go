@@ -0,0 +1,53 @@
+package converter
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// quantizeAlpha reduces the number of alpha levels the same way libwebp does for alpha_quality,
+// which go-webp keeps private: quality 100 keeps alpha as is, and lower values make it cheaper to compress.
+func quantizeAlpha(img image.Image, quality int) image.Image {
+	if quality >= 100 {
+		return img
+	}
+	if isOpaque(img) {
+		return img
+	}
+
+	levels := 16 + (quality-70)*8
+	if quality <= 70 {
+		levels = 2 + quality/5
+	}
+
+	var lut [256]uint8
+	for a := range lut {
+		lut[a] = uint8((a*(levels-1) + 127) / 255 * 255 / (levels - 1))
+	}
+
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			c.A = lut[c.A]
+			dst.SetNRGBA(x-b.Min.X, y-b.Min.Y, c)
+		}
+	}
+	return dst
+}
+
+// flattenAlpha composes img over an opaque background, for encoders which drop alpha.
+func flattenAlpha(img image.Image, background color.Color) image.Image {
+	if isOpaque(img) {
+		return img
+	}
+
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,213 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+
+	"golang.org/x/image/draw"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+)
+
+// animation holds fully composed frames, so every frame can be resized and encoded on its own.
+type animation struct {
+	frames []image.Image
+	// delays are frame durations in milliseconds
+	delays []int
+	// loopCount is the number of times the animation is played, 0 meaning forever
+	loopCount int
+}
+
+func (a *animation) mapFrames(fn func(image.Image) image.Image) {
+	for i := range a.frames {
+		a.frames[i] = fn(a.frames[i])
+	}
+}
+
+// decodeAnimation decodes all frames of animated inputs. Other inputs, including animations of a single frame,
+// are decoded as still images and become a single-frame animation.
+func decodeAnimation(inputMetadata *input.MetadataStruct, reader io.Reader, opts decodeOptions) (*animation, *imageMetadata, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read input: %w", err)
+	}
+
+	d, err := findDecoder(inputMetadata, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.decodeAnimation != nil {
+		frames, err := d.countFrames(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("count %s frames: %w", d.name, err)
+		}
+		if frames > 1 {
+			if err := CheckFileSize(opts.limits, int64(len(data))); err != nil {
+				return nil, nil, err
+			}
+			cfg, err := d.decodeConfig(data, 0)
+			if err != nil {
+				return nil, nil, fmt.Errorf("decode %s config: %w", d.name, err)
+			}
+			// every frame is composed onto a canvas of its own, so animations over the limits are refused
+			// instead of being downscaled
+			if err := checkDimensions(opts.limits, cfg.Width, cfg.Height); err != nil {
+				return nil, nil, err
+			}
+			if err := checkAnimationPixels(opts.limits, cfg.Width, cfg.Height, frames); err != nil {
+				return nil, nil, err
+			}
+
+			anim, err := d.decodeAnimation(data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("decode animated %s: %w", d.name, err)
+			}
+			meta := extractMetadata(data)
+			anim.mapFrames(colorConversion(meta, opts.colorProfile))
+			if !opts.ignoreOrientation {
+				orientation := exifOrientation(meta.exif)
+				anim.mapFrames(func(frame image.Image) image.Image {
+					return applyOrientation(frame, orientation)
+				})
+				meta.oriented = orientation > 1
+			}
+			return anim, meta, nil
+		}
+	}
+
+	src, meta, err := decodeImage(inputMetadata, bytes.NewReader(data), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &animation{frames: []image.Image{src}, delays: []int{0}}, meta, nil
+}
+
+// splitGif splits a GIF into its header (signature, logical screen descriptor and global color table) and the blocks
+// of every frame, each along with the extensions preceding its image descriptor, without decoding any of them.
+func splitGif(data []byte) ([]byte, [][]byte, error) {
+	if len(data) < 13 {
+		return nil, nil, fmt.Errorf("gif header is truncated")
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+	if pos > len(data) {
+		return nil, nil, fmt.Errorf("gif is truncated")
+	}
+	header := data[:pos]
+
+	skipSubBlocks := func() error {
+		for pos < len(data) {
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return nil
+			}
+		}
+		return fmt.Errorf("gif is truncated")
+	}
+
+	frames := [][]byte{}
+	frameStart := pos
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: introducer, label and data sub-blocks
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return nil, nil, err
+			}
+		case 0x2C: // image descriptor, optionally followed by a local color table, then LZW code size and data sub-blocks
+			if pos+10 > len(data) {
+				return nil, nil, fmt.Errorf("gif is truncated")
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++
+			if err := skipSubBlocks(); err != nil {
+				return nil, nil, err
+			}
+			frames = append(frames, data[frameStart:pos])
+			frameStart = pos
+		case 0x3B: // trailer
+			return header, frames, nil
+		default:
+			return nil, nil, fmt.Errorf("unknown gif block 0x%02x", data[pos])
+		}
+	}
+	return header, frames, nil
+}
+
+func gifFrameCount(data []byte) (int, error) {
+	_, frames, err := splitGif(data)
+	return len(frames), err
+}
+
+// decodeGifAnimation composes the frames of a GIF, applying their disposal methods,
+// and stops after the frame with index lastFrame (or at the end, if lastFrame is negative).
+// With lastFrame set, only the frame it stopped at is kept. Frames are decoded one at a time,
+// so only the canvas and the current frame are held on top of the kept frames.
+func decodeGifAnimation(data []byte, lastFrame int) (*animation, error) {
+	header, frames, err := splitGif(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("gif has no frames")
+	}
+	cfg, err := gif.DecodeConfig(bytes.NewReader(header))
+	if err != nil {
+		return nil, err
+	}
+
+	anim := &animation{}
+	canvas := image.NewNRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	var previous *image.NRGBA
+	for i, frameData := range frames {
+		// every frame is decoded as a GIF of its own, sharing the header and global color table
+		g, err := gif.DecodeAll(io.MultiReader(bytes.NewReader(header), bytes.NewReader(frameData), bytes.NewReader([]byte{0x3B})))
+		if err != nil {
+			return nil, fmt.Errorf("decode frame %d: %w", i, err)
+		}
+		if i == 0 {
+			switch {
+			case g.LoopCount == 0:
+				anim.loopCount = 0
+			case g.LoopCount < 0:
+				anim.loopCount = 1
+			default:
+				anim.loopCount = g.LoopCount + 1
+			}
+		}
+		frame, disposal, delay := g.Image[0], g.Disposal[0], g.Delay[0]*10
+
+		if disposal == gif.DisposalPrevious {
+			previous = cloneNRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		stop := lastFrame >= 0 && (i >= lastFrame || i == len(frames)-1)
+		if lastFrame < 0 || stop {
+			anim.frames = append(anim.frames, cloneNRGBA(canvas))
+			anim.delays = append(anim.delays, delay)
+		}
+		if stop {
+			break
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return anim, nil
+}
@@ -0,0 +1,237 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"slices"
+	"testing"
+
+	"github.com/kolesa-team/go-webp/encoder"
+	"github.com/kolesa-team/go-webp/webp"
+)
+
+var (
+	testRed   = color.NRGBA{255, 0, 0, 255}
+	testGreen = color.NRGBA{0, 255, 0, 255}
+	testBlue  = color.NRGBA{0, 0, 255, 255}
+)
+
+func testSolidImage(r image.Rectangle, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// checkPixels compares pixels of a composed frame against the expected colors.
+func checkPixels(t *testing.T, frame image.Image, want map[image.Point]color.NRGBA) {
+	t.Helper()
+	for p, c := range want {
+		if got := color.NRGBAModel.Convert(frame.At(p.X, p.Y)).(color.NRGBA); got != c {
+			t.Errorf("pixel %v: got %v, want %v", p, got, c)
+		}
+	}
+}
+
+// testGif is a 4x4 animation of a red background, a green 2x2 square at the top-left corner, and a blue dot
+// at the bottom-right corner, with the disposal of the green square as given.
+func testGif(t *testing.T, disposal byte) []byte {
+	t.Helper()
+	palette := color.Palette{color.NRGBA{}, testRed, testGreen, testBlue}
+	frame := func(r image.Rectangle, index uint8) *image.Paletted {
+		img := image.NewPaletted(r, palette)
+		for i := range img.Pix {
+			img.Pix[i] = index
+		}
+		return img
+	}
+
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{
+		Image:     []*image.Paletted{frame(image.Rect(0, 0, 4, 4), 1), frame(image.Rect(0, 0, 2, 2), 2), frame(image.Rect(3, 3, 4, 4), 3)},
+		Delay:     []int{1, 2, 3},
+		Disposal:  []byte{gif.DisposalNone, disposal, gif.DisposalNone},
+		LoopCount: 2,
+		Config:    image.Config{ColorModel: palette, Width: 4, Height: 4},
+	})
+	if err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeGifAnimation(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		disposal byte
+		// topLeft is the top-left pixel of the last frame, which depends on the disposal of the green square
+		topLeft color.NRGBA
+	}{
+		{"unspecified", 0, testGreen},
+		{"none", gif.DisposalNone, testGreen},
+		{"background", gif.DisposalBackground, color.NRGBA{}},
+		{"previous", gif.DisposalPrevious, testRed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := testGif(t, tc.disposal)
+
+			count, err := gifFrameCount(data)
+			if err != nil || count != 3 {
+				t.Fatalf("got %d frames (%v), want 3", count, err)
+			}
+
+			anim, err := decodeGifAnimation(data, -1)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if len(anim.frames) != 3 {
+				t.Fatalf("got %d frames, want 3", len(anim.frames))
+			}
+			if !slices.Equal(anim.delays, []int{10, 20, 30}) {
+				t.Errorf("got delays %v, want [10 20 30]", anim.delays)
+			}
+			if anim.loopCount != 3 {
+				t.Errorf("got loop count %d, want 3", anim.loopCount)
+			}
+			checkPixels(t, anim.frames[1], map[image.Point]color.NRGBA{{0, 0}: testGreen, {3, 3}: testRed})
+			checkPixels(t, anim.frames[2], map[image.Point]color.NRGBA{{0, 0}: tc.topLeft, {2, 2}: testRed, {3, 3}: testBlue})
+
+			still, err := decodeGifAnimation(data, 1)
+			if err != nil {
+				t.Fatalf("decode frame 1: %v", err)
+			}
+			if len(still.frames) != 1 {
+				t.Fatalf("got %d frames for frame 1, want 1", len(still.frames))
+			}
+			checkPixels(t, still.frames[0], map[image.Point]color.NRGBA{{0, 0}: testGreen, {3, 3}: testRed})
+		})
+	}
+}
+
+func newTestWebpOptions() (*encoder.Options, error) {
+	return encoder.NewLosslessEncoderOptions(encoder.PresetDefault, 0)
+}
+
+// testAnmfChunk encodes img as a frame placed at (x, y) of the canvas.
+func testAnmfChunk(t *testing.T, img image.Image, x, y, duration int, flags byte) riffChunk {
+	t.Helper()
+	opts, err := newTestWebpOptions()
+	if err != nil {
+		t.Fatalf("encoder options: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, opts); err != nil {
+		t.Fatalf("encode frame: %v", err)
+	}
+	chunks, err := parseWebpContainer(buf.Bytes())
+	if err != nil {
+		t.Fatalf("parse frame: %v", err)
+	}
+
+	data := make([]byte, 16)
+	putUint24(data[0:3], x/2)
+	putUint24(data[3:6], y/2)
+	putUint24(data[6:9], img.Bounds().Dx()-1)
+	putUint24(data[9:12], img.Bounds().Dy()-1)
+	putUint24(data[12:15], duration)
+	data[15] = flags
+	for _, chunk := range chunks {
+		data = appendRiffChunk(data, chunk)
+	}
+	return riffChunk{"ANMF", data}
+}
+
+func testAnimatedWebp(t *testing.T, width, height, loopCount int, frames ...riffChunk) []byte {
+	t.Helper()
+	animData := make([]byte, 6)
+	binary.LittleEndian.PutUint16(animData[4:6], uint16(loopCount))
+
+	var buf bytes.Buffer
+	chunks := append([]riffChunk{vp8xChunk(vp8xFlagAnimation|vp8xFlagAlpha, width, height), {"ANIM", animData}}, frames...)
+	if err := writeWebpContainer(&buf, chunks); err != nil {
+		t.Fatalf("write webp: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeWebpAnimation(t *testing.T) {
+	data := testAnimatedWebp(t, 4, 4, 4,
+		testAnmfChunk(t, testSolidImage(image.Rect(0, 0, 4, 4), testRed), 0, 0, 10, 0),
+		testAnmfChunk(t, testSolidImage(image.Rect(0, 0, 2, 2), testGreen), 2, 2, 20, anmfFlagDisposeBackground),
+		testAnmfChunk(t, testSolidImage(image.Rect(0, 0, 1, 1), testBlue), 0, 0, 30, anmfFlagNoBlend),
+	)
+
+	count, err := webpFrameCount(data)
+	if err != nil || count != 3 {
+		t.Fatalf("got %d frames (%v), want 3", count, err)
+	}
+
+	anim, err := decodeWebpAnimation(data, -1)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(anim.frames) != 3 {
+		t.Fatalf("got %d frames, want 3", len(anim.frames))
+	}
+	if !slices.Equal(anim.delays, []int{10, 20, 30}) {
+		t.Errorf("got delays %v, want [10 20 30]", anim.delays)
+	}
+	if anim.loopCount != 4 {
+		t.Errorf("got loop count %d, want 4", anim.loopCount)
+	}
+	checkPixels(t, anim.frames[1], map[image.Point]color.NRGBA{{0, 0}: testRed, {1, 1}: testRed, {2, 2}: testGreen, {3, 3}: testGreen})
+	checkPixels(t, anim.frames[2], map[image.Point]color.NRGBA{{0, 0}: testBlue, {1, 1}: testRed, {2, 2}: {}, {3, 3}: {}})
+
+	still, err := decodeWebpAnimation(data, 1)
+	if err != nil {
+		t.Fatalf("decode frame 1: %v", err)
+	}
+	if len(still.frames) != 1 || still.delays[0] != 20 {
+		t.Fatalf("got %d frames with delays %v for frame 1, want 1 of 20", len(still.frames), still.delays)
+	}
+	checkPixels(t, still.frames[0], map[image.Point]color.NRGBA{{0, 0}: testRed, {2, 2}: testGreen})
+
+	outside := testAnimatedWebp(t, 4, 4, 0, testAnmfChunk(t, testSolidImage(image.Rect(0, 0, 4, 4), testRed), 2, 0, 10, 0))
+	if _, err := decodeWebpAnimation(outside, -1); err == nil {
+		t.Error("decoded a frame lying outside the canvas")
+	}
+}
+
+func TestEncodeWebpAnimation(t *testing.T) {
+	colors := []color.NRGBA{testRed, testGreen, testBlue}
+	want := &animation{delays: []int{10, 20, 30}, loopCount: 4}
+	for _, c := range colors {
+		want.frames = append(want.frames, testSolidImage(image.Rect(0, 0, 3, 2), c))
+	}
+
+	var buf bytes.Buffer
+	if err := encodeWebpAnimation(&buf, want, newTestWebpOptions); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got, err := decodeWebpAnimation(buf.Bytes(), -1)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if len(got.frames) != len(want.frames) {
+		t.Fatalf("got %d frames, want %d", len(got.frames), len(want.frames))
+	}
+	if !slices.Equal(got.delays, want.delays) {
+		t.Errorf("got delays %v, want %v", got.delays, want.delays)
+	}
+	if got.loopCount != want.loopCount {
+		t.Errorf("got loop count %d, want %d", got.loopCount, want.loopCount)
+	}
+	for i, frame := range got.frames {
+		if frame.Bounds() != want.frames[i].Bounds() {
+			t.Errorf("frame %d: got bounds %v, want %v", i, frame.Bounds(), want.frames[i].Bounds())
+		}
+		checkPixels(t, frame, map[image.Point]color.NRGBA{{0, 0}: colors[i], {2, 1}: colors[i]})
+	}
+}
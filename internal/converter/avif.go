@@ -0,0 +1,125 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"strings"
+
+	"github.com/Kagami/go-avif"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/output"
+)
+
+var _ Converter = (*AvifConverter)(nil)
+
+// AvifConverter encodes with libaom through cgo, so building requires libaom headers and library,
+// the same way WebpConverter requires libwebp.
+type AvifConverter struct {
+	size          sizeOptions
+	decodeOptions decodeOptions
+	overlay       *overlay
+	options       *avif.Options
+	background    color.Color
+	outputClient  output.OutputClient
+}
+
+func NewAvifConverter(cfg *config.ConverterConfig) (Converter, error) {
+	if cfg.Type != "avif" {
+		return nil, fmt.Errorf("invalid storage type for AvifConverter")
+	}
+	avifCfg := cfg.Config.(*config.AvifConfig)
+
+	if avifCfg.Animation.Mode == "keep" {
+		return nil, fmt.Errorf("keeping animation is only supported for webp output")
+	}
+	if (cfg.Metadata.Mode != "" && cfg.Metadata.Mode != "strip") || cfg.ColorProfile.Embed {
+		return nil, fmt.Errorf("metadata is not supported for avif output")
+	}
+	if cfg.ColorProfile.Target != "" && cfg.ColorProfile.Target != "srgb" {
+		return nil, fmt.Errorf("avif output can't embed a color profile, so it only supports the srgb target")
+	}
+
+	size, err := newSizeOptions(avifCfg.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	decodeOptions, err := newDecodeOptions(cfg, avifCfg.Animation, avifCfg.IgnoreOrientation)
+	if err != nil {
+		return nil, err
+	}
+
+	overlay, err := newOverlay(cfg.Overlay)
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize overlay: %w", err)
+	}
+
+	outputClient, err := output.NewOutputClientMap[cfg.Output.Storage.Type](&cfg.Output)
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize output client: %w", err)
+	}
+
+	// libaom quantizer goes from 0 (lossless) to 63 (worst), so the 1-100 quality is mapped onto it in reverse
+	options := &avif.Options{
+		Speed:   avifCfg.Speed,
+		Quality: avif.MaxQuality - (avifCfg.Quality*avif.MaxQuality+50)/100,
+	}
+
+	// go-avif only encodes 4:2:0 without alpha, so transparent inputs are flattened onto the background
+	var background color.Color = color.White
+	if avifCfg.Background != "" {
+		if background, err = parseHexColor(avifCfg.Background); err != nil {
+			return nil, err
+		}
+	}
+
+	return &AvifConverter{size, decodeOptions, overlay, options, background, outputClient}, nil
+}
+
+func (p *AvifConverter) Process(source *Source, outputName string) error {
+	inputMetadata := source.Metadata
+	decoded, err := source.decode(p.decodeOptions)
+	if err != nil {
+		return err
+	}
+	meta := decoded.meta
+
+	drawOverlay, err := p.overlay.drawer(inputMetadata, meta)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	img := flattenAlpha(drawOverlay(p.size.resizeShared(decoded, focalPoint(inputMetadata, meta))), p.background)
+	if err := avif.Encode(&buf, img, p.options); err != nil {
+		return err
+	}
+
+	writer, err := p.outputClient.GetWriter(outputName, inputMetadata, "image/avif", nil)
+	if err != nil {
+		return fmt.Errorf("fail to initialize writer for output: %w", err)
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(buf.Bytes())
+	return err
+}
+
+func (p *AvifConverter) DeductOutputPath(inputPath string) string {
+	pathParts := strings.Split(inputPath, ".")
+	if len(pathParts) < 2 {
+		return inputPath + ".avif"
+	}
+	pathParts[len(pathParts)-1] = "avif"
+	return strings.Join(pathParts, ".")
+}
+
+func (p *AvifConverter) ReadMetadata(path string) (*output.MetadataStruct, error) {
+	return p.outputClient.ReadMetadata(path)
+}
+
+func (p *AvifConverter) IsMissing(path string) bool {
+	return p.outputClient.IsMissing(path)
+}
@@ -0,0 +1,109 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/output"
+)
+
+var _ Converter = (*BlurhashConverter)(nil)
+
+// BlurhashConverter writes a JSON object with the BlurHash and ThumbHash placeholders of an image.
+type BlurhashConverter struct {
+	componentsX   int
+	componentsY   int
+	decodeOptions decodeOptions
+	outputClient  output.OutputClient
+}
+
+type placeholderOutput struct {
+	Width     int    `json:"Width"`
+	Height    int    `json:"Height"`
+	BlurHash  string `json:"BlurHash"`
+	ThumbHash string `json:"ThumbHash"`
+}
+
+func NewBlurhashConverter(cfg *config.ConverterConfig) (Converter, error) {
+	if cfg.Type != "blurhash" {
+		return nil, fmt.Errorf("invalid storage type for BlurhashConverter")
+	}
+	blurhashCfg := cfg.Config.(*config.BlurhashConfig)
+
+	if blurhashCfg.Animation.Mode == "keep" {
+		return nil, fmt.Errorf("keeping animation is only supported for webp output")
+	}
+
+	componentsX, componentsY := 4, 3
+	if blurhashCfg.ComponentsX != 0 {
+		componentsX = blurhashCfg.ComponentsX
+	}
+	if blurhashCfg.ComponentsY != 0 {
+		componentsY = blurhashCfg.ComponentsY
+	}
+	if componentsX < 1 || componentsX > 9 || componentsY < 1 || componentsY > 9 {
+		return nil, fmt.Errorf("blurhash components should be between 1 and 9, got %dx%d", componentsX, componentsY)
+	}
+
+	decodeOptions, err := newDecodeOptions(cfg, blurhashCfg.Animation, blurhashCfg.IgnoreOrientation)
+	if err != nil {
+		return nil, err
+	}
+
+	outputClient, err := output.NewOutputClientMap[cfg.Output.Storage.Type](&cfg.Output)
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize output client: %w", err)
+	}
+
+	return &BlurhashConverter{componentsX, componentsY, decodeOptions, outputClient}, nil
+}
+
+func (p *BlurhashConverter) Process(source *Source, outputName string) error {
+	inputMetadata := source.Metadata
+	decoded, err := source.decode(p.decodeOptions)
+	if err != nil {
+		return err
+	}
+	src := decoded.img
+
+	small := placeholderSource(src)
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(placeholderOutput{
+		Width:     src.Bounds().Dx(),
+		Height:    src.Bounds().Dy(),
+		BlurHash:  encodeBlurHash(small, p.componentsX, p.componentsY),
+		ThumbHash: base64.StdEncoding.EncodeToString(encodeThumbHash(small)),
+	}); err != nil {
+		return err
+	}
+
+	writer, err := p.outputClient.GetWriter(outputName, inputMetadata, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("fail to initialize writer for output: %w", err)
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(buf.Bytes())
+	return err
+}
+
+func (p *BlurhashConverter) DeductOutputPath(inputPath string) string {
+	pathParts := strings.Split(inputPath, ".")
+	if len(pathParts) < 2 {
+		return inputPath + ".blurhash.json"
+	}
+	pathParts[len(pathParts)-1] = "blurhash.json"
+	return strings.Join(pathParts, ".")
+}
+
+func (p *BlurhashConverter) ReadMetadata(path string) (*output.MetadataStruct, error) {
+	return p.outputClient.ReadMetadata(path)
+}
+
+func (p *BlurhashConverter) IsMissing(path string) bool {
+	return p.outputClient.IsMissing(path)
+}
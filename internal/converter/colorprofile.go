@@ -0,0 +1,399 @@
+package converter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"log/slog"
+	"math"
+	"unicode/utf16"
+
+	"golang.org/x/image/draw"
+)
+
+// colorProfile is an RGB matrix/TRC profile: per-channel tone curves followed by a matrix to the D50 XYZ connection space.
+type colorProfile struct {
+	name string
+	// toXYZ maps linear RGB to D50 XYZ, row by row
+	toXYZ [9]float64
+	// decode turns encoded channel values into linear light
+	decode [3]func(float64) float64
+	// encode is the inverse of the tone curve, only known for target profiles
+	encode func(float64) float64
+	icc    []byte
+}
+
+// sRGBCurve and gammaCurve hold parametric curve parameters in the order of the ICC para type.
+var (
+	sRGBCurve  = []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045}
+	gammaCurve = []float64{563.0 / 256}
+)
+
+// targetColorProfiles are the profiles pixels can be converted to, with colorants already adapted to D50.
+var targetColorProfiles = map[string]*colorProfile{
+	"srgb": newTargetProfile("sRGB", [9]float64{
+		0.4360747, 0.3850649, 0.1430804,
+		0.2225045, 0.7168786, 0.0606169,
+		0.0139322, 0.0971045, 0.7141733,
+	}, sRGBCurve),
+	"display-p3": newTargetProfile("Display P3", [9]float64{
+		0.5151021, 0.2919769, 0.1571510,
+		0.2411824, 0.6922352, 0.0665824,
+		-0.0010502, 0.0418808, 0.7840893,
+	}, sRGBCurve),
+	"adobe-rgb": newTargetProfile("Adobe RGB (1998)", [9]float64{
+		0.6097559, 0.2052401, 0.1492240,
+		0.3111242, 0.6256560, 0.0632197,
+		0.0194811, 0.0608902, 0.7448387,
+	}, gammaCurve),
+}
+
+func newTargetProfile(name string, toXYZ [9]float64, curve []float64) *colorProfile {
+	decode := parametricCurve(curve)
+	var encode func(float64) float64
+	if len(curve) == 1 {
+		encode = func(v float64) float64 { return math.Pow(v, 1/curve[0]) }
+	} else {
+		g, a, b, c, d := curve[0], curve[1], curve[2], curve[3], curve[4]
+		encode = func(v float64) float64 {
+			if v >= d*c {
+				return (math.Pow(v, 1/g) - b) / a
+			}
+			return v / c
+		}
+	}
+
+	return &colorProfile{
+		name:   name,
+		toXYZ:  toXYZ,
+		decode: [3]func(float64) float64{decode, decode, decode},
+		encode: encode,
+		icc:    writeICCProfile(name, toXYZ, curve),
+	}
+}
+
+// parametricCurve evaluates an ICC parametric curve, selecting the function type by the number of parameters.
+func parametricCurve(p []float64) func(float64) float64 {
+	switch len(p) {
+	case 1:
+		return func(x float64) float64 { return math.Pow(x, p[0]) }
+	case 3:
+		return func(x float64) float64 {
+			if x >= -p[2]/p[1] {
+				return math.Pow(p[1]*x+p[2], p[0])
+			}
+			return 0
+		}
+	case 4:
+		return func(x float64) float64 {
+			if x >= -p[2]/p[1] {
+				return math.Pow(p[1]*x+p[2], p[0]) + p[3]
+			}
+			return p[3]
+		}
+	case 5:
+		return func(x float64) float64 {
+			if x >= p[4] {
+				return math.Pow(p[1]*x+p[2], p[0])
+			}
+			return p[3] * x
+		}
+	default:
+		return func(x float64) float64 {
+			if x >= p[4] {
+				return math.Pow(p[1]*x+p[2], p[0]) + p[5]
+			}
+			return p[3]*x + p[6]
+		}
+	}
+}
+
+var iccParametricParams = map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+
+// parseICCProfile reads an RGB matrix/TRC profile. LUT-based and non-RGB profiles are reported as unsupported.
+func parseICCProfile(data []byte) (*colorProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("not an icc profile")
+	}
+	if string(data[16:20]) != "RGB " {
+		return nil, fmt.Errorf("unsupported icc color space: %q", data[16:20])
+	}
+
+	tags := map[string][]byte{}
+	count := binary.BigEndian.Uint32(data[128:132])
+	for i := range uint64(count) {
+		entry := 132 + i*12
+		if entry+12 > uint64(len(data)) {
+			return nil, fmt.Errorf("icc tag table is truncated")
+		}
+		offset := uint64(binary.BigEndian.Uint32(data[entry+4:]))
+		size := uint64(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset+size > uint64(len(data)) || size < 8 {
+			continue
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	profile := &colorProfile{icc: data}
+	for i, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag, ok := tags[name]
+		if !ok || len(tag) < 20 || string(tag[0:4]) != "XYZ " {
+			return nil, fmt.Errorf("icc profile has no %s matrix column", name)
+		}
+		for j := range 3 {
+			profile.toXYZ[j*3+i] = s15Fixed16(tag[8+j*4:])
+		}
+	}
+	for i, name := range []string{"rTRC", "gTRC", "bTRC"} {
+		tag, ok := tags[name]
+		if !ok {
+			return nil, fmt.Errorf("icc profile has no %s curve", name)
+		}
+		curve, err := parseICCCurve(tag)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+		profile.decode[i] = curve
+	}
+
+	return profile, nil
+}
+
+func parseICCCurve(tag []byte) (func(float64) float64, error) {
+	switch string(tag[0:4]) {
+	case "curv":
+		if len(tag) < 12 {
+			return nil, fmt.Errorf("curve is truncated")
+		}
+		count := int(binary.BigEndian.Uint32(tag[8:12]))
+		if len(tag) < 12+count*2 {
+			return nil, fmt.Errorf("curve is truncated")
+		}
+		switch count {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			return parametricCurve([]float64{float64(binary.BigEndian.Uint16(tag[12:14])) / 256}), nil
+		}
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 65535
+		}
+		return func(x float64) float64 {
+			pos := x * float64(count-1)
+			i := min(int(pos), count-2)
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, nil
+	case "para":
+		if len(tag) < 12 {
+			return nil, fmt.Errorf("curve is truncated")
+		}
+		n, ok := iccParametricParams[binary.BigEndian.Uint16(tag[8:10])]
+		if !ok || len(tag) < 12+n*4 {
+			return nil, fmt.Errorf("unsupported parametric curve")
+		}
+		params := make([]float64, n)
+		for i := range params {
+			params[i] = s15Fixed16(tag[12+i*4:])
+		}
+		return parametricCurve(params), nil
+	default:
+		return nil, fmt.Errorf("unsupported curve type: %q", tag[0:4])
+	}
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func appendS15Fixed16(buf []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint32(buf, uint32(int32(math.Round(v*65536))))
+}
+
+// writeICCProfile writes a minimal ICC v4 display profile with the given colorants and a parametric tone curve.
+func writeICCProfile(name string, toXYZ [9]float64, curve []float64) []byte {
+	mluc := func(text string) []byte {
+		encoded := utf16.Encode([]rune(text))
+		buf := []byte("mluc\x00\x00\x00\x00")
+		buf = binary.BigEndian.AppendUint32(buf, 1)
+		buf = binary.BigEndian.AppendUint32(buf, 12)
+		buf = append(buf, "enUS"...)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(encoded)*2))
+		buf = binary.BigEndian.AppendUint32(buf, 28)
+		for _, r := range encoded {
+			buf = binary.BigEndian.AppendUint16(buf, r)
+		}
+		return buf
+	}
+	xyz := func(x, y, z float64) []byte {
+		buf := []byte("XYZ \x00\x00\x00\x00")
+		return appendS15Fixed16(appendS15Fixed16(appendS15Fixed16(buf, x), y), z)
+	}
+
+	para := []byte("para\x00\x00\x00\x00")
+	para = binary.BigEndian.AppendUint16(para, map[int]uint16{1: 0, 5: 3}[len(curve)])
+	para = append(para, 0, 0)
+	for _, p := range curve {
+		para = appendS15Fixed16(para, p)
+	}
+
+	// Bradford adaptation from the D65 white point of the encodings to D50
+	chad := []byte("sf32\x00\x00\x00\x00")
+	for _, v := range []float64{1.0478112, 0.0228866, -0.0501270, 0.0295424, 0.9904844, -0.0170491, -0.0092345, 0.0150436, 0.7521316} {
+		chad = appendS15Fixed16(chad, v)
+	}
+
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"desc", mluc(name)},
+		{"cprt", mluc("No copyright, use freely")},
+		{"wtpt", xyz(0.9642, 1, 0.8249)},
+		{"chad", chad},
+		{"rXYZ", xyz(toXYZ[0], toXYZ[3], toXYZ[6])},
+		{"gXYZ", xyz(toXYZ[1], toXYZ[4], toXYZ[7])},
+		{"bXYZ", xyz(toXYZ[2], toXYZ[5], toXYZ[8])},
+		{"rTRC", para},
+		{"gTRC", para},
+		{"bTRC", para},
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[8:], 0x04300000)
+	copy(header[12:], "mntrRGB XYZ ")
+	copy(header[36:], "acsp")
+	copy(header[68:], appendS15Fixed16(appendS15Fixed16(appendS15Fixed16(nil, 0.9642), 1), 0.8249))
+
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	data := []byte{}
+	dataStart := len(header) + 4 + len(tags)*12
+	offsets := map[string]int{}
+	for _, tag := range tags {
+		// the three tone curves are identical, so they share their data
+		offset, ok := offsets[string(tag.data)]
+		if !ok {
+			offset = dataStart + len(data)
+			offsets[string(tag.data)] = offset
+			data = append(data, tag.data...)
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		table = append(table, tag.signature...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+	}
+
+	profile := append(append(header, table...), data...)
+	binary.BigEndian.PutUint32(profile[0:4], uint32(len(profile)))
+	return profile
+}
+
+// colorConversion returns a function converting pixels described by the ICC profile of meta into the target profile,
+// and updates meta, so its profile keeps describing the pixels. Untagged inputs are treated as sRGB.
+// Inputs with profiles which can't be parsed are left as is, and keep their profile whatever the metadata policy is.
+func colorConversion(meta *imageMetadata, target *colorProfile) func(image.Image) image.Image {
+	identity := func(img image.Image) image.Image { return img }
+
+	source := targetColorProfiles["srgb"]
+	if len(meta.icc) > 0 {
+		profile, err := parseICCProfile(meta.icc)
+		if err != nil {
+			slog.Debug("skip color conversion", slog.String("error", err.Error()))
+			meta.unconverted = true
+			return identity
+		}
+		source = profile
+	}
+
+	if source != target {
+		meta.icc = target.icc
+	}
+	if sameColorProfile(source, target) {
+		return identity
+	}
+
+	// the matrix goes from source linear RGB to target linear RGB through D50 XYZ
+	matrix := multiply3x3(invert3x3(target.toXYZ), source.toXYZ)
+
+	const lutSize = 4096
+	var decodeLUT [3][lutSize + 1]float64
+	var encodeLUT [lutSize + 1]uint16
+	for i := range lutSize + 1 {
+		x := float64(i) / lutSize
+		for c := range 3 {
+			decodeLUT[c][i] = source.decode[c](x)
+		}
+		encodeLUT[i] = uint16(math.Round(min(max(target.encode(x), 0), 1) * 65535))
+	}
+	lookup := func(v float64) float64 {
+		pos := min(max(v, 0), 1) * lutSize
+		i := min(int(pos), lutSize-1)
+		return float64(encodeLUT[i]) + (float64(encodeLUT[i+1])-float64(encodeLUT[i]))*(pos-float64(i))
+	}
+
+	return func(img image.Image) image.Image {
+		b := img.Bounds()
+		dst := image.NewNRGBA64(b)
+		draw.Draw(dst, b, img, b.Min, draw.Src)
+
+		for i := 0; i+8 <= len(dst.Pix); i += 8 {
+			var linear [3]float64
+			for c := range 3 {
+				v := binary.BigEndian.Uint16(dst.Pix[i+c*2:])
+				pos := int(v) * lutSize
+				idx, frac := pos/65535, float64(pos%65535)/65535
+				if idx == lutSize {
+					idx, frac = lutSize-1, 1
+				}
+				linear[c] = decodeLUT[c][idx] + (decodeLUT[c][idx+1]-decodeLUT[c][idx])*frac
+			}
+			for c := range 3 {
+				v := matrix[c*3]*linear[0] + matrix[c*3+1]*linear[1] + matrix[c*3+2]*linear[2]
+				binary.BigEndian.PutUint16(dst.Pix[i+c*2:], uint16(math.Round(lookup(v))))
+			}
+		}
+		return dst
+	}
+}
+
+// sameColorProfile tells whether converting between two profiles would change no pixel value noticeably.
+func sameColorProfile(a, b *colorProfile) bool {
+	if a == b {
+		return true
+	}
+	for i := range a.toXYZ {
+		if math.Abs(a.toXYZ[i]-b.toXYZ[i]) > 0.002 {
+			return false
+		}
+	}
+	for c := range 3 {
+		for i := range 33 {
+			x := float64(i) / 32
+			if math.Abs(a.decode[c](x)-b.decode[c](x)) > 1.0/1024 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func multiply3x3(a, b [9]float64) [9]float64 {
+	var m [9]float64
+	for r := range 3 {
+		for c := range 3 {
+			m[r*3+c] = a[r*3]*b[c] + a[r*3+1]*b[3+c] + a[r*3+2]*b[6+c]
+		}
+	}
+	return m
+}
+
+func invert3x3(m [9]float64) [9]float64 {
+	det := m[0]*(m[4]*m[8]-m[5]*m[7]) - m[1]*(m[3]*m[8]-m[5]*m[6]) + m[2]*(m[3]*m[7]-m[4]*m[6])
+	return [9]float64{
+		(m[4]*m[8] - m[5]*m[7]) / det, (m[2]*m[7] - m[1]*m[8]) / det, (m[1]*m[5] - m[2]*m[4]) / det,
+		(m[5]*m[6] - m[3]*m[8]) / det, (m[0]*m[8] - m[2]*m[6]) / det, (m[2]*m[3] - m[0]*m[5]) / det,
+		(m[3]*m[7] - m[4]*m[6]) / det, (m[1]*m[6] - m[0]*m[7]) / det, (m[0]*m[4] - m[1]*m[3]) / det,
+	}
+}
@@ -0,0 +1,182 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log/slog"
+	"os"
+	"path"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/output"
+)
+
+// ContactSheet renders all inputs of an album into a single JPEG grid of tiles, optionally captioned with file names.
+type ContactSheet struct {
+	fileName   string
+	columns    int
+	maxTiles   int
+	tileSize   int
+	spacing    int
+	background color.Color
+	tile       sizeOptions
+	// captions is only used for its font and color, and is nil when captions are disabled
+	captions     *overlay
+	quality      int
+	outputClient output.OutputClient
+}
+
+type ContactSheetInput struct {
+	Name     string
+	Metadata *input.MetadataStruct
+}
+
+func NewContactSheet(cfg *config.ContactSheetConfig) (*ContactSheet, error) {
+	c := &ContactSheet{
+		fileName:   cfg.FileName,
+		columns:    cfg.Columns,
+		maxTiles:   cfg.MaxTiles,
+		tileSize:   cfg.TileSize,
+		spacing:    cfg.Spacing,
+		background: color.Black,
+		quality:    cfg.Quality,
+	}
+	if c.fileName == "" {
+		c.fileName = "contact-sheet.jpg"
+	}
+
+	var err error
+	if cfg.Background != "" {
+		if c.background, err = parseHexColor(cfg.Background); err != nil {
+			return nil, err
+		}
+	}
+
+	tileMode := cfg.TileMode
+	if tileMode == "" {
+		tileMode = "fill"
+	}
+	if c.tile, err = newSizeOptions(config.SizeConfig{Mode: tileMode, MaxWidth: cfg.TileSize, MaxHeight: cfg.TileSize, Background: cfg.Background}); err != nil {
+		return nil, err
+	}
+
+	if cfg.Captions {
+		c.captions = &overlay{color: color.White}
+		fontData := goregular.TTF
+		if cfg.FontPath != "" {
+			if fontData, err = os.ReadFile(cfg.FontPath); err != nil {
+				return nil, fmt.Errorf("read font: %w", err)
+			}
+		}
+		if c.captions.font, err = opentype.Parse(fontData); err != nil {
+			return nil, fmt.Errorf("parse font: %w", err)
+		}
+		if cfg.CaptionColor != "" {
+			if c.captions.color, err = parseHexColor(cfg.CaptionColor); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if c.outputClient, err = output.NewOutputClientMap[cfg.Output.Storage.Type](&cfg.Output); err != nil {
+		return nil, fmt.Errorf("fail to initialize output client: %w", err)
+	}
+
+	return c, nil
+}
+
+// TileCount returns how many of the album's inputs get a tile on the sheet.
+func (c *ContactSheet) TileCount(inputCount int) int {
+	if c.maxTiles > 0 {
+		return min(inputCount, c.maxTiles)
+	}
+	return inputCount
+}
+
+// Tile renders the tile of an input. Sources are decoded to sRGB, so every sheet shares the same decode.
+func (c *ContactSheet) Tile(source *Source) (image.Image, error) {
+	decoded, err := source.decode(decodeOptions{colorProfile: targetColorProfiles["srgb"]})
+	if err != nil {
+		return nil, err
+	}
+	return c.tile.resizeShared(decoded, focalPoint(source.Metadata, decoded.meta)), nil
+}
+
+// Process renders the contact sheet of an album from the tiles of its inputs. Inputs without a tile are left empty.
+func (c *ContactSheet) Process(albumMetadata *input.MetadataStruct, inputs []ContactSheetInput, tiles []image.Image, outputName string) error {
+	inputs = inputs[:c.TileCount(len(inputs))]
+	if len(inputs) == 0 {
+		return fmt.Errorf("album has no inputs")
+	}
+
+	captionSize := float64(max(10, c.tileSize/12))
+	captionHeight := 0
+	if c.captions != nil {
+		captionHeight = int(captionSize*1.4 + 0.5)
+	}
+
+	columns := min(c.columns, len(inputs))
+	rows := (len(inputs) + columns - 1) / columns
+	cellHeight := c.tileSize + captionHeight
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*c.tileSize+(columns+1)*c.spacing, rows*cellHeight+(rows+1)*c.spacing))
+	draw.Draw(sheet, sheet.Rect, image.NewUniform(c.background), image.Point{}, draw.Src)
+
+	for i, in := range inputs {
+		at := image.Pt(c.spacing+(i%columns)*(c.tileSize+c.spacing), c.spacing+(i/columns)*(cellHeight+c.spacing))
+
+		if tile := tiles[i]; tile != nil {
+			b := tile.Bounds()
+			offset := image.Pt((c.tileSize-b.Dx())/2, (c.tileSize-b.Dy())/2)
+			draw.Draw(sheet, b.Sub(b.Min).Add(at).Add(offset), tile, b.Min, draw.Over)
+		}
+
+		if c.captions == nil {
+			continue
+		}
+		caption, err := c.captions.renderText(path.Base(in.Name), captionSize)
+		if err != nil {
+			slog.Warn("skip contact sheet caption", slog.String("input_path", in.Name), slog.String("error", err.Error()))
+			continue
+		}
+		// captions wider than the tile are cut off on the right, narrower ones are centered
+		cb := caption.Bounds()
+		captionAt := at.Add(image.Pt(max(0, (c.tileSize-cb.Dx())/2), c.tileSize+(captionHeight-cb.Dy())/2))
+		clip := image.Rect(at.X, at.Y+c.tileSize, at.X+c.tileSize, at.Y+cellHeight)
+		draw.Draw(sheet, cb.Sub(cb.Min).Add(captionAt).Intersect(clip), caption, cb.Min, draw.Over)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, sheet, &jpeg.Options{Quality: c.quality}); err != nil {
+		return err
+	}
+
+	writer, err := c.outputClient.GetWriter(outputName, albumMetadata, "image/jpeg", nil)
+	if err != nil {
+		return fmt.Errorf("fail to initialize writer for output: %w", err)
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(buf.Bytes())
+	return err
+}
+
+// DeductOutputPath returns the path of the contact sheet of the album, which is the directory of its inputs.
+func (c *ContactSheet) DeductOutputPath(album string) string {
+	return path.Join(album, c.fileName)
+}
+
+func (c *ContactSheet) ReadMetadata(path string) (*output.MetadataStruct, error) {
+	return c.outputClient.ReadMetadata(path)
+}
+
+func (c *ContactSheet) IsMissing(path string) bool {
+	return c.outputClient.IsMissing(path)
+}
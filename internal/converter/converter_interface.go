@@ -1,21 +1,23 @@
 package converter
 
 import (
-	"io"
-
 	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
-	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
 	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/output"
 )
 
 type Converter interface {
-	Process(inputMetadata *input.MetadataStruct, reader io.Reader, outputName string) error
+	Process(source *Source, outputName string) error
 	DeductOutputPath(inputPath string) string
 	ReadMetadata(path string) (*output.MetadataStruct, error)
 	IsMissing(path string) bool
 }
 
 var NewConverterMap = map[string]func(cfg *config.ConverterConfig) (Converter, error){
-	"webp": NewWebpConverter,
-	"jpeg": NewJpegConverter,
+	"webp":     NewWebpConverter,
+	"jpeg":     NewJpegConverter,
+	"avif":     NewAvifConverter,
+	"png":      NewPngConverter,
+	"blurhash": NewBlurhashConverter,
+	"lqip":     NewLqipConverter,
+	"palette":  NewPaletteConverter,
 }
@@ -0,0 +1,99 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+)
+
+type decodeOptions struct {
+	// frame is the index of the frame (animations) or page (multi-page TIFFs) decoded as a still image
+	frame int
+	// ignoreOrientation keeps pixels as stored, instead of turning them upright according to EXIF
+	ignoreOrientation bool
+	// colorProfile is the profile pixels are converted to
+	colorProfile *colorProfile
+	// limits are the limits of the input storage, set by the Source of the input
+	limits config.InputLimitsConfig
+}
+
+func newDecodeOptions(cfg *config.ConverterConfig, animationCfg config.AnimationConfig, ignoreOrientation bool) (decodeOptions, error) {
+	target := cfg.ColorProfile.Target
+	if target == "" {
+		target = "srgb"
+	}
+	profile, ok := targetColorProfiles[target]
+	if !ok {
+		return decodeOptions{}, fmt.Errorf("unsupported color profile: %s", cfg.ColorProfile.Target)
+	}
+
+	return decodeOptions{frame: animationCfg.Frame, ignoreOrientation: ignoreOrientation, colorProfile: profile}, nil
+}
+
+// decodeImage decodes a still image. For animated inputs the frame with the given index is composed and returned,
+// and for multi-page TIFFs the page with that index. Short animations and documents fall back to their last frame.
+// The metadata of the input is returned along with the image.
+func decodeImage(inputMetadata *input.MetadataStruct, reader io.Reader, opts decodeOptions) (image.Image, *imageMetadata, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read input: %w", err)
+	}
+
+	src, err := decodeData(inputMetadata, data, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := extractMetadata(data)
+	src = colorConversion(meta, opts.colorProfile)(src)
+	if !opts.ignoreOrientation {
+		orientation := exifOrientation(meta.exif)
+		src = applyOrientation(src, orientation)
+		meta.oriented = orientation > 1
+	}
+	return src, meta, nil
+}
+
+// decodeData decodes a still image, refusing it when its dimensions exceed the limits, unless it can be
+// downscaled to fit them while decoding.
+func decodeData(inputMetadata *input.MetadataStruct, data []byte, opts decodeOptions) (image.Image, error) {
+	d, err := findDecoder(inputMetadata, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckFileSize(opts.limits, int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	cfg, err := d.decodeConfig(data, opts.frame)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s config: %w", d.name, err)
+	}
+	if err := checkDimensions(opts.limits, cfg.Width, cfg.Height); err != nil {
+		if !opts.limits.Downscale || d.decodeScaled == nil {
+			return nil, err
+		}
+		width, height := limitedSize(opts.limits, cfg.Width, cfg.Height)
+		slog.Debug("downscale input while decoding", slog.String("name", inputMetadata.Name), slog.String("reason", err.Error()),
+			slog.Int("width", width), slog.Int("height", height))
+		src, scaleErr := d.decodeScaled(data, width, height)
+		switch {
+		case errors.Is(scaleErr, errors.ErrUnsupported):
+			return nil, err
+		case scaleErr != nil:
+			return nil, fmt.Errorf("decode scaled %s: %w", d.name, scaleErr)
+		}
+		return src, nil
+	}
+
+	src, err := d.decode(data, opts.frame)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", d.name, err)
+	}
+	return src, nil
+}
@@ -0,0 +1,212 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"slices"
+
+	webpdecoder "github.com/kolesa-team/go-webp/decoder"
+	"github.com/kolesa-team/go-webp/webp"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+)
+
+// decoder decodes inputs of one format, which is recognized by the leading bytes of the data rather than
+// the declared content type, as storages often get it wrong.
+type decoder struct {
+	name string
+	// contentTypes are the content types the format is declared with, the canonical one first
+	contentTypes []string
+	sniff        func(data []byte) bool
+	// decodeConfig reads the dimensions of what decode would decode, without decoding the pixels
+	decodeConfig func(data []byte, frame int) (image.Config, error)
+	// decode decodes the frame (animations) or page (multi-page documents) with the given index as a still image
+	decode func(data []byte, frame int) (image.Image, error)
+	// decodeScaled decodes a still image at the given size, and is nil for formats which can't scale while decoding.
+	// It returns errors.ErrUnsupported for inputs of the format which can't be scaled.
+	decodeScaled func(data []byte, width, height int) (image.Image, error)
+	// countFrames counts the frames of animated inputs without decoding them, and is nil for formats without animation
+	countFrames func(data []byte) (int, error)
+	// decodeAnimation decodes all frames, and is nil for formats without animation
+	decodeAnimation func(data []byte) (*animation, error)
+}
+
+// decoders are tried in the order of registration, so formats built on top of others (RAW files are TIFFs)
+// are registered first.
+var decoders []*decoder
+
+func registerDecoder(d *decoder) {
+	decoders = append(decoders, d)
+}
+
+func init() {
+	registerDecoder(&decoder{
+		name:         "raw preview",
+		contentTypes: []string{"image/x-dcraw", "image/x-adobe-dng", "image/x-canon-cr2", "image/x-nikon-nef", "image/x-sony-arw", "image/tiff"},
+		sniff: func(data []byte) bool {
+			_, ok := sniffRaw(data)
+			return ok
+		},
+		decodeConfig: func(data []byte, _ int) (image.Config, error) {
+			return decodeRawPreviewConfig(data)
+		},
+		decode: func(data []byte, _ int) (image.Image, error) {
+			return decodeRawPreview(data)
+		},
+	})
+	registerDecoder(&decoder{
+		name:         "jpeg",
+		contentTypes: []string{"image/jpeg", "image/jpg", "image/pjpeg"},
+		sniff: func(data []byte) bool {
+			return bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF})
+		},
+		decodeConfig: func(data []byte, _ int) (image.Config, error) {
+			return jpeg.DecodeConfig(bytes.NewReader(data))
+		},
+		decode: func(data []byte, _ int) (image.Image, error) {
+			return jpeg.Decode(bytes.NewReader(data))
+		},
+	})
+	registerDecoder(&decoder{
+		name:         "png",
+		contentTypes: []string{"image/png", "image/apng"},
+		sniff: func(data []byte) bool {
+			return bytes.HasPrefix(data, pngHeader)
+		},
+		decodeConfig: func(data []byte, _ int) (image.Config, error) {
+			return png.DecodeConfig(bytes.NewReader(data))
+		},
+		decode: func(data []byte, _ int) (image.Image, error) {
+			return png.Decode(bytes.NewReader(data))
+		},
+	})
+	registerDecoder(&decoder{
+		name:         "webp",
+		contentTypes: []string{"image/webp"},
+		sniff: func(data []byte) bool {
+			return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+		},
+		decodeConfig: func(data []byte, _ int) (image.Config, error) {
+			return webp.DecodeConfig(bytes.NewReader(data), nil)
+		},
+		decode: func(data []byte, frame int) (image.Image, error) {
+			if isAnimatedWebp(data) {
+				anim, err := decodeWebpAnimation(data, frame)
+				if err != nil {
+					return nil, err
+				}
+				return anim.frames[len(anim.frames)-1], nil
+			}
+			return webp.Decode(bytes.NewReader(data), nil)
+		},
+		decodeScaled: func(data []byte, width, height int) (image.Image, error) {
+			if isAnimatedWebp(data) {
+				return nil, errors.ErrUnsupported
+			}
+			return webp.Decode(bytes.NewReader(data), &webpdecoder.Options{Scale: image.Rect(0, 0, width, height)})
+		},
+		countFrames: webpFrameCount,
+		decodeAnimation: func(data []byte) (*animation, error) {
+			return decodeWebpAnimation(data, -1)
+		},
+	})
+	registerDecoder(&decoder{
+		name:         "gif",
+		contentTypes: []string{"image/gif"},
+		sniff: func(data []byte) bool {
+			return bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))
+		},
+		decodeConfig: func(data []byte, _ int) (image.Config, error) {
+			return gif.DecodeConfig(bytes.NewReader(data))
+		},
+		decode: func(data []byte, frame int) (image.Image, error) {
+			anim, err := decodeGifAnimation(data, frame)
+			if err != nil {
+				return nil, err
+			}
+			return anim.frames[len(anim.frames)-1], nil
+		},
+		countFrames: gifFrameCount,
+		decodeAnimation: func(data []byte) (*animation, error) {
+			return decodeGifAnimation(data, -1)
+		},
+	})
+	registerDecoder(&decoder{
+		name:         "tiff",
+		contentTypes: []string{"image/tiff", "image/tiff-fx"},
+		sniff: func(data []byte) bool {
+			return bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))
+		},
+		decodeConfig: func(data []byte, frame int) (image.Config, error) {
+			page, err := tiffPage(data, frame)
+			if err != nil {
+				return image.Config{}, fmt.Errorf("find tiff page: %w", err)
+			}
+			return tiff.DecodeConfig(bytes.NewReader(page))
+		},
+		decode: func(data []byte, frame int) (image.Image, error) {
+			page, err := tiffPage(data, frame)
+			if err != nil {
+				return nil, fmt.Errorf("find tiff page: %w", err)
+			}
+			return tiff.Decode(bytes.NewReader(page))
+		},
+	})
+	registerDecoder(&decoder{
+		name:         "bmp",
+		contentTypes: []string{"image/bmp", "image/x-ms-bmp", "image/x-bmp"},
+		// "BM" alone is too common a prefix, so the size of the DIB header following the file header is checked too
+		sniff: func(data []byte) bool {
+			if len(data) < 18 || !bytes.HasPrefix(data, []byte("BM")) {
+				return false
+			}
+			return slices.Contains([]uint32{12, 40, 52, 56, 64, 108, 124}, binary.LittleEndian.Uint32(data[14:18]))
+		},
+		decodeConfig: func(data []byte, _ int) (image.Config, error) {
+			return bmp.DecodeConfig(bytes.NewReader(data))
+		},
+		decode: func(data []byte, _ int) (image.Image, error) {
+			return bmp.Decode(bytes.NewReader(data))
+		},
+	})
+}
+
+// genericContentTypes say nothing about the format, so sniffing a format for them isn't a mismatch.
+var genericContentTypes = []string{"", "application/octet-stream", "binary/octet-stream"}
+
+// findDecoder returns the decoder for the sniffed format of data, falling back to the declared content type
+// of the input when no format is recognized.
+func findDecoder(inputMetadata *input.MetadataStruct, data []byte) (*decoder, error) {
+	for _, d := range decoders {
+		if !d.sniff(data) {
+			continue
+		}
+		if !slices.Contains(d.contentTypes, inputMetadata.ContentType) {
+			level := slog.LevelWarn
+			if slices.Contains(genericContentTypes, inputMetadata.ContentType) {
+				level = slog.LevelDebug
+			}
+			slog.Log(context.Background(), level, "sniffed content type differs from the declared one",
+				slog.String("name", inputMetadata.Name), slog.String("content_type", d.contentTypes[0]), slog.String("declared_content_type", inputMetadata.ContentType))
+		}
+		return d, nil
+	}
+
+	for _, d := range decoders {
+		if slices.Contains(d.contentTypes, inputMetadata.ContentType) {
+			slog.Debug("fall back to declared content type", slog.String("name", inputMetadata.Name), slog.String("declared_content_type", inputMetadata.ContentType))
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported content type: %s", inputMetadata.ContentType)
+}
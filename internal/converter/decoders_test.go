@@ -0,0 +1,59 @@
+package converter
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+)
+
+func testBmpHeader(dibSize uint32) []byte {
+	data := make([]byte, 18)
+	copy(data, "BM")
+	binary.LittleEndian.PutUint32(data[14:], dibSize)
+	return data
+}
+
+func TestFindDecoder(t *testing.T) {
+	dng := buildTestExif(binary.LittleEndian, []tiffEntry{{tag: tiffTagDNGVersion, typ: 1, count: 4, value: []byte{1, 4, 0, 0}}}, nil, nil)
+	tiff := buildTestExif(binary.BigEndian, []tiffEntry{testExifMake}, nil, nil)
+
+	for _, tc := range []struct {
+		name        string
+		data        []byte
+		contentType string
+		// want is the name of the expected decoder, or empty when none should be found
+		want string
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0}, "image/jpeg", "jpeg"},
+		{"png", pngHeader, "image/png", "png"},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "image/webp", "webp"},
+		{"gif87a", []byte("GIF87a"), "image/gif", "gif"},
+		{"gif89a", []byte("GIF89a"), "image/gif", "gif"},
+		{"tiff", tiff, "image/tiff", "tiff"},
+		{"dng", dng, "image/tiff", "raw preview"},
+		{"bmp", testBmpHeader(40), "image/bmp", "bmp"},
+		{"bmp v5", testBmpHeader(124), "", "bmp"},
+		{"sniffed over mismatching content type", pngHeader, "image/jpeg", "png"},
+		{"sniffed over generic content type", []byte{0xFF, 0xD8, 0xFF}, "application/octet-stream", "jpeg"},
+		{"sniffed without content type", []byte("GIF89a"), "", "gif"},
+		{"content type when not sniffed", []byte("RIFF\x00\x00\x00\x00WAVE"), "image/webp", "webp"},
+		{"bmp prefix of text", []byte("BMW owners club newsletter"), "text/plain", ""},
+		{"bmp with unknown dib header", testBmpHeader(41), "application/octet-stream", ""},
+		{"bmp content type with unknown dib header", testBmpHeader(41), "image/bmp", "bmp"},
+		{"unknown", []byte("just some text"), "image/heic", ""},
+		{"empty", nil, "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := findDecoder(&input.MetadataStruct{Name: tc.name, ContentType: tc.contentType}, tc.data)
+			switch {
+			case tc.want == "" && err == nil:
+				t.Errorf("got %s decoder, want none", d.name)
+			case tc.want != "" && err != nil:
+				t.Errorf("got error %v, want %s decoder", err, tc.want)
+			case tc.want != "" && d.name != tc.want:
+				t.Errorf("got %s decoder, want %s", d.name, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const tiffTagOrientation = 0x0112
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// extractExif finds the EXIF block of JPEG, PNG, WebP and TIFF-based files and returns it as a TIFF structure.
+// The container format is recognized by its signature, so nil is returned for anything else.
+func extractExif(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		for _, segment := range jpegSegments(data) {
+			if segment.marker == 0xE1 && bytes.HasPrefix(segment.data, exifHeader) {
+				return segment.data[len(exifHeader):]
+			}
+		}
+	case bytes.HasPrefix(data, pngHeader):
+		for _, chunk := range pngChunks(data) {
+			if chunk.fourCC == "eXIf" {
+				return chunk.data
+			}
+		}
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		chunks, err := parseWebpContainer(data)
+		if err != nil {
+			return nil
+		}
+		for _, chunk := range chunks {
+			if chunk.fourCC == "EXIF" {
+				// some writers keep the JPEG APP1 prefix in the chunk
+				return bytes.TrimPrefix(chunk.data, exifHeader)
+			}
+		}
+	default:
+		if _, err := parseTiff(data); err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// jpegSegments returns the marker segments of a JPEG file up to the start of scan.
+func jpegSegments(data []byte) []jpegSegment {
+	segments := []jpegSegment{}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			break
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			pos += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		segments = append(segments, jpegSegment{marker, data[pos+4 : pos+2+length]})
+		pos += 2 + length
+	}
+	return segments
+}
+
+// pngChunks returns the chunks of a PNG file, reusing riffChunk as both are FourCC-tagged blobs.
+func pngChunks(data []byte) []riffChunk {
+	chunks := []riffChunk{}
+	pos := len(pngHeader)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		if length < 0 || pos+12+length > len(data) {
+			break
+		}
+		chunks = append(chunks, riffChunk{string(data[pos+4 : pos+8]), data[pos+8 : pos+8+length]})
+		pos += 12 + length
+	}
+	return chunks
+}
+
+// exifOrientation returns the orientation tag of IFD0, defaulting to 1 (as stored) when it is absent or invalid.
+func exifOrientation(exif []byte) int {
+	t, err := parseTiff(exif)
+	if err != nil {
+		return 1
+	}
+	entries, _, err := t.readIFD(t.firstIFD())
+	if err != nil {
+		return 1
+	}
+	entry, ok := findTiffEntry(entries, tiffTagOrientation)
+	if !ok {
+		return 1
+	}
+	orientation, ok := entry.uint(t, 0)
+	if !ok || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return int(orientation)
+}
+
+// applyOrientation rotates and flips src so it is displayed upright, according to an EXIF orientation value.
+func applyOrientation(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	b := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, src, b.Min, draw.Src)
+	}
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := range dh {
+		for x := range dw {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], rgba.Pix[sy*rgba.Stride+sx*4:sy*rgba.Stride+sx*4+4])
+		}
+	}
+
+	return dst
+}
+
+const (
+	tiffTagExifIFD    = 0x8769
+	tiffTagGPSIFD     = 0x8825
+	tiffTagInteropIFD = 0xA005
+	tiffTagMakerNote  = 0x927C
+)
+
+type exifIFD int
+
+const (
+	exifIFD0 exifIFD = iota
+	exifIFDExif
+	exifIFDGPS
+)
+
+// exifTag addresses a tag inside one of the EXIF IFDs.
+type exifTag struct {
+	ifd exifIFD
+	tag uint16
+}
+
+// exifTagNames are the tags which can be listed by name in a metadata whitelist.
+var exifTagNames = map[string]exifTag{
+	"ImageDescription":      {exifIFD0, 0x010E},
+	"Make":                  {exifIFD0, 0x010F},
+	"Model":                 {exifIFD0, 0x0110},
+	"Orientation":           {exifIFD0, tiffTagOrientation},
+	"Software":              {exifIFD0, 0x0131},
+	"DateTime":              {exifIFD0, 0x0132},
+	"Artist":                {exifIFD0, 0x013B},
+	"Copyright":             {exifIFD0, 0x8298},
+	"ExposureTime":          {exifIFDExif, 0x829A},
+	"FNumber":               {exifIFDExif, 0x829D},
+	"ExposureProgram":       {exifIFDExif, 0x8822},
+	"ISOSpeedRatings":       {exifIFDExif, 0x8827},
+	"DateTimeOriginal":      {exifIFDExif, 0x9003},
+	"DateTimeDigitized":     {exifIFDExif, 0x9004},
+	"OffsetTime":            {exifIFDExif, 0x9010},
+	"OffsetTimeOriginal":    {exifIFDExif, 0x9011},
+	"OffsetTimeDigitized":   {exifIFDExif, 0x9012},
+	"ExposureBiasValue":     {exifIFDExif, 0x9204},
+	"MeteringMode":          {exifIFDExif, 0x9207},
+	"Flash":                 {exifIFDExif, 0x9209},
+	"FocalLength":           {exifIFDExif, 0x920A},
+	"UserComment":           {exifIFDExif, 0x9286},
+	"ColorSpace":            {exifIFDExif, 0xA001},
+	"WhiteBalance":          {exifIFDExif, 0xA403},
+	"FocalLengthIn35mmFilm": {exifIFDExif, 0xA405},
+	"CameraOwnerName":       {exifIFDExif, 0xA430},
+	"BodySerialNumber":      {exifIFDExif, 0xA431},
+	"LensMake":              {exifIFDExif, 0xA433},
+	"LensModel":             {exifIFDExif, 0xA434},
+}
+
+// exifDescriptiveIFD0Tags are the IFD0 tags worth keeping from TIFF-based inputs, where IFD0 also describes
+// the layout of the (RAW) image data, which means nothing for the output.
+var exifDescriptiveIFD0Tags = map[uint16]struct{}{
+	0x010D: {}, 0x010E: {}, 0x010F: {}, 0x0110: {}, tiffTagOrientation: {}, 0x011A: {}, 0x011B: {}, 0x0128: {},
+	0x0131: {}, 0x0132: {}, 0x013B: {}, 0x013C: {}, 0x8298: {},
+}
+
+// exifUnrelocatableTags reference data by offsets, so they can't be copied into a rebuilt EXIF block.
+var exifUnrelocatableTags = map[uint16]struct{}{
+	0x0111: {}, 0x0117: {}, 0x0144: {}, 0x0145: {}, tiffTagSubIFDs: {}, tiffTagJPEGInterchange: {}, tiffTagJPEGInterchangeBytes: {},
+	tiffTagExifIFD: {}, tiffTagGPSIFD: {}, tiffTagInteropIFD: {}, tiffTagMakerNote: {},
+}
+
+// rebuildExif writes a new EXIF block holding the entries of IFD0, the Exif IFD and the GPS IFD accepted by keep.
+// When resetOrientation is set, a kept orientation is replaced by 1, as the pixels have already been turned upright.
+func rebuildExif(exif []byte, keep func(tag exifTag) bool, resetOrientation bool) []byte {
+	t, err := parseTiff(exif)
+	if err != nil {
+		return nil
+	}
+	ifd0, _, err := t.readIFD(t.firstIFD())
+	if err != nil {
+		return nil
+	}
+
+	ifds := map[exifIFD][]tiffEntry{exifIFD0: ifd0}
+	for pointer, ifd := range map[uint16]exifIFD{tiffTagExifIFD: exifIFDExif, tiffTagGPSIFD: exifIFDGPS} {
+		entry, ok := findTiffEntry(ifd0, pointer)
+		if !ok {
+			continue
+		}
+		offset, ok := entry.uint(t, 0)
+		if !ok {
+			continue
+		}
+		if entries, _, err := t.readIFD(offset); err == nil {
+			ifds[ifd] = entries
+		}
+	}
+
+	for ifd, entries := range ifds {
+		kept := []tiffEntry{}
+		for _, entry := range entries {
+			if _, ok := exifUnrelocatableTags[entry.tag]; ok || !keep(exifTag{ifd, entry.tag}) {
+				continue
+			}
+			if ifd == exifIFD0 && entry.tag == tiffTagOrientation && resetOrientation {
+				entry = tiffEntry{tag: tiffTagOrientation, typ: 3, count: 1, value: t.order.AppendUint16(nil, 1)}
+			}
+			kept = append(kept, entry)
+		}
+		ifds[ifd] = kept
+	}
+
+	if len(ifds[exifIFD0])+len(ifds[exifIFDExif])+len(ifds[exifIFDGPS]) == 0 {
+		return nil
+	}
+
+	// pointers get their final offsets once the size of IFD0 (which includes them) is known
+	ifd0 = ifds[exifIFD0]
+	pointers := map[exifIFD]uint16{exifIFDExif: tiffTagExifIFD, exifIFDGPS: tiffTagGPSIFD}
+	for _, ifd := range []exifIFD{exifIFDExif, exifIFDGPS} {
+		if len(ifds[ifd]) > 0 {
+			ifd0 = append(ifd0, tiffEntry{tag: pointers[ifd], typ: 4, count: 1, value: make([]byte, 4)})
+		}
+	}
+	for _, entries := range [][]tiffEntry{ifd0, ifds[exifIFDExif], ifds[exifIFDGPS]} {
+		slices.SortFunc(entries, func(a, b tiffEntry) int { return int(a.tag) - int(b.tag) })
+	}
+
+	offset := 8 + tiffIFDSize(ifd0)
+	for _, ifd := range []exifIFD{exifIFDExif, exifIFDGPS} {
+		if len(ifds[ifd]) == 0 {
+			continue
+		}
+		for i := range ifd0 {
+			if ifd0[i].tag == pointers[ifd] {
+				ifd0[i].value = t.order.AppendUint32(nil, offset)
+			}
+		}
+		offset += tiffIFDSize(ifds[ifd])
+	}
+
+	buf := make([]byte, 8)
+	copy(buf, exif[0:4])
+	t.order.PutUint32(buf[4:8], 8)
+	buf = appendTiffIFD(buf, t.order, ifd0, 0)
+	for _, ifd := range []exifIFD{exifIFDExif, exifIFDGPS} {
+		if len(ifds[ifd]) > 0 {
+			buf = appendTiffIFD(buf, t.order, ifds[ifd], 0)
+		}
+	}
+	return buf
+}
+
+// resetExifOrientation returns a copy of the EXIF block with the IFD0 orientation set to 1, keeping everything else in place.
+func resetExifOrientation(exif []byte) []byte {
+	t, err := parseTiff(exif)
+	if err != nil {
+		return exif
+	}
+	offset := t.firstIFD()
+	if uint64(offset)+2 > uint64(len(exif)) {
+		return exif
+	}
+	count := uint32(t.order.Uint16(exif[offset:]))
+	for i := range count {
+		entryOffset := offset + 2 + i*12
+		if uint64(entryOffset)+12 > uint64(len(exif)) {
+			break
+		}
+		if t.order.Uint16(exif[entryOffset:]) == tiffTagOrientation && t.order.Uint16(exif[entryOffset+2:]) == 3 {
+			patched := make([]byte, len(exif))
+			copy(patched, exif)
+			t.order.PutUint16(patched[entryOffset+8:], 1)
+			return patched
+		}
+	}
+	return exif
+}
+
+// exifString returns an ASCII tag of IFD0 or the Exif IFD, with its trailing NUL and spaces trimmed.
+func exifString(exif []byte, tag exifTag) string {
+	t, err := parseTiff(exif)
+	if err != nil {
+		return ""
+	}
+	entries, _, err := t.readIFD(t.firstIFD())
+	if err != nil {
+		return ""
+	}
+	if tag.ifd == exifIFDExif {
+		pointer, ok := findTiffEntry(entries, tiffTagExifIFD)
+		if !ok {
+			return ""
+		}
+		offset, ok := pointer.uint(t, 0)
+		if !ok {
+			return ""
+		}
+		if entries, _, err = t.readIFD(offset); err != nil {
+			return ""
+		}
+	}
+	entry, ok := findTiffEntry(entries, tag.tag)
+	if !ok || entry.typ != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(entry.value), "\x00 ")
+}
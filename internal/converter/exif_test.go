@@ -0,0 +1,185 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+var (
+	testExifMake             = tiffEntry{tag: tiffTagMake, typ: 2, count: 5, value: []byte("Sony\x00")}
+	testExifModel            = tiffEntry{tag: 0x0110, typ: 2, count: 4, value: []byte("A7\x00\x00")}
+	testExifDateTimeOriginal = tiffEntry{tag: 0x9003, typ: 2, count: 20, value: []byte("2024:05:01 12:00:00\x00")}
+	testExifLensModel        = tiffEntry{tag: 0xA434, typ: 2, count: 7, value: []byte("FE 50mm")}
+	testExifMakerNote        = tiffEntry{tag: tiffTagMakerNote, typ: 7, count: 6, value: []byte("secret")}
+	testExifGPSLatitudeRef   = tiffEntry{tag: 0x0001, typ: 2, count: 2, value: []byte("N\x00")}
+)
+
+func testExifOrientation(order tiffByteOrder, orientation uint16) tiffEntry {
+	return tiffEntry{tag: tiffTagOrientation, typ: 3, count: 1, value: order.AppendUint16(nil, orientation)}
+}
+
+// buildTestExif writes an EXIF block with IFD0 pointing to the Exif and GPS IFDs when they have entries.
+func buildTestExif(order tiffByteOrder, ifd0, exif, gps []tiffEntry) []byte {
+	ifd0 = append([]tiffEntry{}, ifd0...)
+	subIFDs := map[uint16][]tiffEntry{tiffTagExifIFD: exif, tiffTagGPSIFD: gps}
+	for _, tag := range []uint16{tiffTagExifIFD, tiffTagGPSIFD} {
+		if len(subIFDs[tag]) > 0 {
+			ifd0 = append(ifd0, tiffEntry{tag: tag, typ: 4, count: 1, value: make([]byte, 4)})
+		}
+	}
+	// pointers are inline, so their values don't change the size of IFD0
+	offset := 8 + tiffIFDSize(ifd0)
+	for i, entry := range ifd0 {
+		if entries, ok := subIFDs[entry.tag]; ok {
+			ifd0[i].value = order.AppendUint32(nil, offset)
+			offset += tiffIFDSize(entries)
+		}
+	}
+
+	buf := []byte("II*\x00")
+	if order == binary.BigEndian {
+		buf = []byte("MM\x00*")
+	}
+	buf = order.AppendUint32(buf, 8)
+	buf = appendTiffIFD(buf, order, ifd0, 0)
+	for _, entries := range [][]tiffEntry{exif, gps} {
+		if len(entries) > 0 {
+			buf = appendTiffIFD(buf, order, entries, 0)
+		}
+	}
+	return buf
+}
+
+// readTestExif returns the values of the entries of IFD0, the Exif IFD and the GPS IFD, leaving out the pointers.
+func readTestExif(t *testing.T, exif []byte) map[exifTag]string {
+	t.Helper()
+	tf, err := parseTiff(exif)
+	if err != nil {
+		t.Fatalf("parse rebuilt exif: %v", err)
+	}
+	ifd0, _, err := tf.readIFD(tf.firstIFD())
+	if err != nil {
+		t.Fatalf("read IFD0: %v", err)
+	}
+
+	values := map[exifTag]string{}
+	for _, entry := range ifd0 {
+		ifd := map[uint16]exifIFD{tiffTagExifIFD: exifIFDExif, tiffTagGPSIFD: exifIFDGPS}[entry.tag]
+		if ifd == exifIFD0 {
+			values[exifTag{exifIFD0, entry.tag}] = string(entry.value)
+			continue
+		}
+		offset, _ := entry.uint(tf, 0)
+		entries, _, err := tf.readIFD(offset)
+		if err != nil {
+			t.Fatalf("read IFD %d: %v", ifd, err)
+		}
+		for _, entry := range entries {
+			values[exifTag{ifd, entry.tag}] = string(entry.value)
+		}
+	}
+	return values
+}
+
+func TestRebuildExif(t *testing.T) {
+	keepAll := func(exifTag) bool { return true }
+
+	for _, order := range []tiffByteOrder{binary.LittleEndian, binary.BigEndian} {
+		ifd0 := []tiffEntry{testExifMake, testExifModel, testExifOrientation(order, 6)}
+		exif := []tiffEntry{testExifDateTimeOriginal, testExifLensModel, testExifMakerNote}
+		gps := []tiffEntry{testExifGPSLatitudeRef}
+
+		for _, tc := range []struct {
+			name             string
+			keep             func(exifTag) bool
+			resetOrientation bool
+			want             map[exifTag]string
+		}{
+			{
+				name: "keep all but maker note",
+				keep: keepAll,
+				want: map[exifTag]string{
+					{exifIFD0, tiffTagMake}:        "Sony\x00",
+					{exifIFD0, 0x0110}:             "A7\x00\x00",
+					{exifIFD0, tiffTagOrientation}: string(order.AppendUint16(nil, 6)),
+					{exifIFDExif, 0x9003}:          "2024:05:01 12:00:00\x00",
+					{exifIFDExif, 0xA434}:          "FE 50mm",
+					{exifIFDGPS, 0x0001}:           "N\x00",
+				},
+			},
+			{
+				name:             "reset orientation",
+				keep:             func(tag exifTag) bool { return tag.ifd == exifIFD0 },
+				resetOrientation: true,
+				want: map[exifTag]string{
+					{exifIFD0, tiffTagMake}:        "Sony\x00",
+					{exifIFD0, 0x0110}:             "A7\x00\x00",
+					{exifIFD0, tiffTagOrientation}: string(order.AppendUint16(nil, 1)),
+				},
+			},
+			{
+				name: "whitelist drops gps",
+				keep: func(tag exifTag) bool {
+					return tag == exifTagNames["Make"] || tag == exifTagNames["LensModel"]
+				},
+				want: map[exifTag]string{
+					{exifIFD0, tiffTagMake}: "Sony\x00",
+					{exifIFDExif, 0xA434}:   "FE 50mm",
+				},
+			},
+			{
+				name: "nothing kept",
+				keep: func(exifTag) bool { return false },
+			},
+		} {
+			t.Run(order.String()+"/"+tc.name, func(t *testing.T) {
+				rebuilt := rebuildExif(buildTestExif(order, ifd0, exif, gps), tc.keep, tc.resetOrientation)
+				if tc.want == nil {
+					if rebuilt != nil {
+						t.Fatalf("got %d bytes of exif, want none", len(rebuilt))
+					}
+					return
+				}
+
+				got := readTestExif(t, rebuilt)
+				if len(got) != len(tc.want) {
+					t.Errorf("got %d tags, want %d: %v", len(got), len(tc.want), got)
+				}
+				for tag, value := range tc.want {
+					if got[tag] != value {
+						t.Errorf("tag %#04x of IFD %d: got %q, want %q", tag.tag, tag.ifd, got[tag], value)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestResetExifOrientation(t *testing.T) {
+	for _, order := range []tiffByteOrder{binary.LittleEndian, binary.BigEndian} {
+		withOrientation := buildTestExif(order, []tiffEntry{testExifMake, testExifOrientation(order, 8)}, []tiffEntry{testExifLensModel}, nil)
+		upright := buildTestExif(order, []tiffEntry{testExifMake, testExifOrientation(order, 1)}, []tiffEntry{testExifLensModel}, nil)
+		withoutOrientation := buildTestExif(order, []tiffEntry{testExifMake}, nil, nil)
+
+		for _, tc := range []struct {
+			name string
+			exif []byte
+			want []byte
+		}{
+			{"orientation", withOrientation, upright},
+			{"no orientation", withoutOrientation, withoutOrientation},
+			{"not exif", []byte("not exif at all"), []byte("not exif at all")},
+		} {
+			t.Run(order.String()+"/"+tc.name, func(t *testing.T) {
+				original := bytes.Clone(tc.exif)
+				if got := resetExifOrientation(tc.exif); !bytes.Equal(got, tc.want) {
+					t.Errorf("got %x, want %x", got, tc.want)
+				}
+				if !bytes.Equal(tc.exif, original) {
+					t.Error("input was modified")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+)
+
+const (
+	xmpNamespaceGFocus = "http://ns.google.com/photos/1.0/focus/"
+	xmpNamespaceMWGRS  = "http://www.metadataworkinggroup.com/schemas/regions/"
+	xmpNamespaceArea   = "http://ns.adobe.com/xmp/sType/Area#"
+)
+
+// focalPoint returns the focal point of an input: the one from its sidecar, or else the one found in its XMP.
+func focalPoint(inputMetadata *input.MetadataStruct, meta *imageMetadata) *input.FocalPoint {
+	if inputMetadata.FocalPoint != nil {
+		return inputMetadata.FocalPoint
+	}
+	if meta == nil || len(meta.xmp) == 0 {
+		return nil
+	}
+	return xmpFocalPoint(meta.xmp)
+}
+
+type xmpRegion struct {
+	regionType string
+	x, y       *float64
+}
+
+// xmpFocalPoint reads the Google focus tag (GFocus:FocalPointX/Y), or else the center of an MWG region,
+// preferring regions of the Focus type over other ones (e.g. faces). Values may be stored as attributes or elements.
+func xmpFocalPoint(xmp []byte) *input.FocalPoint {
+	var gFocusX, gFocusY *float64
+	regions := []*xmpRegion{}
+	open := []*xmpRegion{}
+
+	set := func(name xml.Name, value string) {
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		switch {
+		case name.Space == xmpNamespaceMWGRS && name.Local == "Type":
+			if len(open) > 0 {
+				open[len(open)-1].regionType = strings.TrimSpace(value)
+			}
+		case err != nil || v < 0 || v > 1:
+		case name.Space == xmpNamespaceGFocus && name.Local == "FocalPointX":
+			gFocusX = &v
+		case name.Space == xmpNamespaceGFocus && name.Local == "FocalPointY":
+			gFocusY = &v
+		case name.Space == xmpNamespaceArea && name.Local == "x" && len(open) > 0:
+			open[len(open)-1].x = &v
+		case name.Space == xmpNamespaceArea && name.Local == "y" && len(open) > 0:
+			open[len(open)-1].y = &v
+		}
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(xmp))
+	var element xml.Name
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			element = t.Name
+			if t.Name.Local == "li" {
+				region := &xmpRegion{}
+				regions = append(regions, region)
+				open = append(open, region)
+			}
+			for _, attr := range t.Attr {
+				set(attr.Name, attr.Value)
+			}
+		case xml.CharData:
+			set(element, string(t))
+		case xml.EndElement:
+			element = xml.Name{}
+			if t.Name.Local == "li" && len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		}
+	}
+
+	if gFocusX != nil && gFocusY != nil {
+		return &input.FocalPoint{X: *gFocusX, Y: *gFocusY}
+	}
+
+	var found *xmpRegion
+	for _, region := range regions {
+		if region.x == nil || region.y == nil {
+			continue
+		}
+		if region.regionType == "Focus" {
+			found = region
+			break
+		}
+		if found == nil {
+			found = region
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	return &input.FocalPoint{X: *found.x, Y: *found.y}
+}
@@ -1,29 +1,26 @@
 package converter
 
 import (
+	"bytes"
 	"fmt"
-	"image"
 	"image/jpeg"
-	"image/png"
-	"io"
-	"log/slog"
 	"path/filepath"
 	"strings"
 
 	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
-	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
 	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/output"
-	"github.com/kolesa-team/go-webp/webp"
-	"golang.org/x/image/draw"
 )
 
 var _ Converter = (*JpegConverter)(nil)
 
 type JpegConverter struct {
-	maxWidth      int
-	maxHeight     int
+	size          sizeOptions
+	decodeOptions decodeOptions
+	overlay       *overlay
+	metadata      *metadataPolicy
 	extensionName string
 	quality       int
+	qualitySearch *qualitySearch
 	outputClient  output.OutputClient
 }
 
@@ -33,6 +30,35 @@ func NewJpegConverter(cfg *config.ConverterConfig) (Converter, error) {
 	}
 	jpegCfg := cfg.Config.(*config.JpegConfig)
 
+	if jpegCfg.Animation.Mode == "keep" {
+		return nil, fmt.Errorf("keeping animation is only supported for webp output")
+	}
+
+	qualitySearch, err := newQualitySearch(jpegCfg.Quality, jpegCfg.MaxBytes, jpegCfg.MinQuality, jpegCfg.MaxQuality, jpegCfg.AutoQuality)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := newMetadataPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := newSizeOptions(jpegCfg.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	decodeOptions, err := newDecodeOptions(cfg, jpegCfg.Animation, jpegCfg.IgnoreOrientation)
+	if err != nil {
+		return nil, err
+	}
+
+	overlay, err := newOverlay(cfg.Overlay)
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize overlay: %w", err)
+	}
+
 	outputClient, err := output.NewOutputClientMap[cfg.Output.Storage.Type](&cfg.Output)
 	if err != nil {
 		return nil, fmt.Errorf("fail to initialize output client: %w", err)
@@ -43,61 +69,56 @@ func NewJpegConverter(cfg *config.ConverterConfig) (Converter, error) {
 		extensionName = "." + jpegCfg.ExtensionName
 	}
 
-	return &JpegConverter{jpegCfg.Size.MaxWidth, jpegCfg.Size.MaxHeight, extensionName, jpegCfg.Quality, outputClient}, nil
+	return &JpegConverter{size, decodeOptions, overlay, metadata, extensionName, jpegCfg.Quality, qualitySearch, outputClient}, nil
 }
 
-func (p *JpegConverter) Process(inputMetadata *input.MetadataStruct, reader io.Reader, outputName string) error {
-	var src image.Image
+func (p *JpegConverter) Process(source *Source, outputName string) error {
+	inputMetadata := source.Metadata
+	decoded, err := source.decode(p.decodeOptions)
+	if err != nil {
+		return err
+	}
+	meta := decoded.meta
 
-	writer, err := p.outputClient.GetWriter(outputName, inputMetadata, "image/jpeg")
+	drawOverlay, err := p.overlay.drawer(inputMetadata, meta)
 	if err != nil {
-		return fmt.Errorf("fail to initialize writer for output: %w", err)
+		return err
 	}
-	defer writer.Close()
 
-	switch inputMetadata.ContentType {
-	case "image/jpeg":
-		src, err = jpeg.Decode(reader)
-		if err != nil {
-			return fmt.Errorf("decode jpeg: %w", err)
-		}
-	case "image/png":
-		src, err = png.Decode(reader)
-		if err != nil {
-			return fmt.Errorf("decode png: %w", err)
+	dst := drawOverlay(p.size.resizeShared(decoded, focalPoint(inputMetadata, meta)))
+	meta = p.metadata.apply(meta)
+	encode := func(quality int) ([]byte, error) {
+		var buf, out bytes.Buffer
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
 		}
-	case "image/webp":
-		src, err = webp.Decode(reader, nil)
-		if err != nil {
-			return fmt.Errorf("decode webp: %w", err)
+		if err := writeJpegWithMetadata(&out, buf.Bytes(), meta); err != nil {
+			return nil, err
 		}
-	default:
-		return fmt.Errorf("unsupported content type: %s", inputMetadata.ContentType)
+		return out.Bytes(), nil
 	}
 
-	xCoef := 1.0
-	if p.maxWidth > 0 {
-		xCoef = float64(p.maxWidth) / float64(src.Bounds().Max.X)
-	}
-	yCoef := 1.0
-	if p.maxHeight > 0 {
-		yCoef = float64(p.maxHeight) / float64(src.Bounds().Max.Y)
+	score := func(data []byte) (float64, error) {
+		decoded, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return 0, fmt.Errorf("decode jpeg for scoring: %w", err)
+		}
+		return ssim(dst, decoded), nil
 	}
-	slog.Debug("calculated coefficients", slog.Float64("x_coef", xCoef), slog.Float64("y_coef", yCoef))
 
-	minCoef := xCoef
-	if yCoef < minCoef {
-		minCoef = yCoef
+	data, misc, err := p.qualitySearch.encode(outputName, p.quality, encode, score)
+	if err != nil {
+		return err
 	}
 
-	if minCoef >= 1.0 {
-		return jpeg.Encode(writer, src, &jpeg.Options{Quality: p.quality})
+	writer, err := p.outputClient.GetWriter(outputName, inputMetadata, "image/jpeg", misc)
+	if err != nil {
+		return fmt.Errorf("fail to initialize writer for output: %w", err)
 	}
+	defer writer.Close()
 
-	dst := image.NewRGBA(image.Rect(0, 0, int(float64(src.Bounds().Max.X)*minCoef+0.5), int(float64(src.Bounds().Max.Y)*minCoef+0.5)))
-	draw.CatmullRom.Scale(dst, dst.Rect, src, src.Bounds(), draw.Over, nil)
-
-	return jpeg.Encode(writer, dst, &jpeg.Options{Quality: p.quality})
+	_, err = writer.Write(data)
+	return err
 }
 
 func (p *JpegConverter) DeductOutputPath(inputPath string) string {
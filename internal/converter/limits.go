@@ -0,0 +1,63 @@
+package converter
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+)
+
+// CheckFileSize refuses inputs larger than the limits allow, so they can be skipped before being downloaded.
+func CheckFileSize(limits config.InputLimitsConfig, size int64) error {
+	if limits.MaxFileSize > 0 && size > limits.MaxFileSize {
+		return fmt.Errorf("input of %d bytes exceeds the limit of %d bytes", size, limits.MaxFileSize)
+	}
+	return nil
+}
+
+// checkDimensions refuses images of width x height pixels exceeding the limits.
+func checkDimensions(limits config.InputLimitsConfig, width, height int) error {
+	switch {
+	case limits.MaxWidth > 0 && width > limits.MaxWidth:
+		return fmt.Errorf("image width of %d pixels exceeds the limit of %d", width, limits.MaxWidth)
+	case limits.MaxHeight > 0 && height > limits.MaxHeight:
+		return fmt.Errorf("image height of %d pixels exceeds the limit of %d", height, limits.MaxHeight)
+	case limits.MaxPixels > 0 && int64(width)*int64(height) > limits.MaxPixels:
+		return fmt.Errorf("image of %dx%d pixels exceeds the limit of %d pixels", width, height, limits.MaxPixels)
+	}
+	return nil
+}
+
+// checkAnimationPixels refuses animations whose frames together exceed the pixel limit, as every frame is composed
+// onto a width x height canvas of its own.
+func checkAnimationPixels(limits config.InputLimitsConfig, width, height, frames int) error {
+	if limits.MaxPixels > 0 && int64(width)*int64(height)*int64(frames) > limits.MaxPixels {
+		return fmt.Errorf("animation of %d frames of %dx%d pixels exceeds the limit of %d pixels", frames, width, height, limits.MaxPixels)
+	}
+	return nil
+}
+
+// limitedSize returns the largest size with the aspect ratio of width x height which fits into the limits.
+func limitedSize(limits config.InputLimitsConfig, width, height int) (int, int) {
+	scale := 1.0
+	if limits.MaxWidth > 0 {
+		scale = min(scale, float64(limits.MaxWidth)/float64(width))
+	}
+	if limits.MaxHeight > 0 {
+		scale = min(scale, float64(limits.MaxHeight)/float64(height))
+	}
+	if limits.MaxPixels > 0 {
+		scale = min(scale, math.Sqrt(float64(limits.MaxPixels)/(float64(width)*float64(height))))
+	}
+	limitedWidth, limitedHeight := max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+
+	// a side clamped to a single pixel leaves the other one over the pixel limit for very thin images
+	if limits.MaxPixels > 0 && int64(limitedWidth)*int64(limitedHeight) > limits.MaxPixels {
+		if limitedWidth > limitedHeight {
+			limitedWidth = int(limits.MaxPixels / int64(limitedHeight))
+		} else {
+			limitedHeight = int(limits.MaxPixels / int64(limitedWidth))
+		}
+	}
+	return limitedWidth, limitedHeight
+}
@@ -0,0 +1,101 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+)
+
+func TestCheckDimensions(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		limits        config.InputLimitsConfig
+		width, height int
+		wantErr       bool
+	}{
+		{"no limits", config.InputLimitsConfig{}, 100000, 100000, false},
+		{"within limits", config.InputLimitsConfig{MaxWidth: 4000, MaxHeight: 3000, MaxPixels: 12_000_000}, 4000, 3000, false},
+		{"over width", config.InputLimitsConfig{MaxWidth: 4000}, 4001, 1, true},
+		{"over height", config.InputLimitsConfig{MaxHeight: 3000}, 1, 3001, true},
+		{"over pixels", config.InputLimitsConfig{MaxPixels: 12_000_000}, 4000, 3001, true},
+		{"pixels beyond int32", config.InputLimitsConfig{MaxPixels: 1 << 32}, 70000, 70000, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := checkDimensions(tc.limits, tc.width, tc.height); (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckAnimationPixels(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		maxPixels             int64
+		width, height, frames int
+		wantErr               bool
+	}{
+		{"no limit", 0, 1000, 1000, 1000, false},
+		{"within limit", 10_000_000, 1000, 1000, 10, false},
+		{"over limit", 10_000_000, 1000, 1000, 11, true},
+		{"frames beyond int32", 1 << 40, 50000, 50000, 1000, true},
+		{"frames within int64", 1 << 40, 50000, 50000, 100, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkAnimationPixels(config.InputLimitsConfig{MaxPixels: tc.maxPixels}, tc.width, tc.height, tc.frames)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLimitedSize(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		limits                config.InputLimitsConfig
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{"no limits", config.InputLimitsConfig{}, 6000, 4000, 6000, 4000},
+		{"within limits", config.InputLimitsConfig{MaxWidth: 8000, MaxHeight: 8000}, 6000, 4000, 6000, 4000},
+		{"width", config.InputLimitsConfig{MaxWidth: 3000}, 6000, 4000, 3000, 2000},
+		{"height", config.InputLimitsConfig{MaxHeight: 1000}, 6000, 4000, 1500, 1000},
+		{"tighter of width and height", config.InputLimitsConfig{MaxWidth: 3000, MaxHeight: 1000}, 6000, 4000, 1500, 1000},
+		{"pixels", config.InputLimitsConfig{MaxPixels: 6_000_000}, 6000, 4000, 3000, 2000},
+		{"odd ratio", config.InputLimitsConfig{MaxWidth: 1000}, 3000, 1999, 1000, 666},
+		{"thin strip", config.InputLimitsConfig{MaxWidth: 100}, 100000, 10, 100, 1},
+		{"thin strip over pixels", config.InputLimitsConfig{MaxPixels: 100}, 100000, 1, 100, 1},
+		{"tall strip over pixels", config.InputLimitsConfig{MaxPixels: 100}, 3, 100000, 1, 100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			width, height := limitedSize(tc.limits, tc.width, tc.height)
+			if width != tc.wantWidth || height != tc.wantHeight {
+				t.Errorf("got %dx%d, want %dx%d", width, height, tc.wantWidth, tc.wantHeight)
+			}
+			if err := checkDimensions(tc.limits, width, height); err != nil {
+				t.Errorf("limited size doesn't fit: %v", err)
+			}
+		})
+	}
+
+	// every size within the limits keeps the aspect ratio within a pixel and never collapses to nothing
+	limits := config.InputLimitsConfig{MaxWidth: 1920, MaxHeight: 1080, MaxPixels: 1_000_000}
+	for width := 1; width <= 20000; width += 997 {
+		for height := 1; height <= 20000; height += 1009 {
+			w, h := limitedSize(limits, width, height)
+			if w < 1 || h < 1 {
+				t.Fatalf("%dx%d: got %dx%d", width, height, w, h)
+			}
+			if err := checkDimensions(limits, w, h); err != nil {
+				t.Fatalf("%dx%d: limited size %dx%d doesn't fit: %v", width, height, w, h, err)
+			}
+			// both sides have to be within a pixel of the same scale, unless a side got clamped to a single pixel
+			wScale := [2]float64{float64(w-1) / float64(width), float64(w+1) / float64(width)}
+			hScale := [2]float64{float64(h-1) / float64(height), float64(h+1) / float64(height)}
+			if w > 1 && h > 1 && (wScale[0] > hScale[1] || hScale[0] > wScale[1]) {
+				t.Errorf("%dx%d: got %dx%d, which is off the aspect ratio by more than a pixel", width, height, w, h)
+			}
+		}
+	}
+}
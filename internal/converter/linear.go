@@ -0,0 +1,72 @@
+package converter
+
+import (
+	"image"
+	"math"
+	"sync"
+)
+
+// sRGBToLinear maps 16-bit sRGB-encoded values to 16-bit linear light, and linearToSRGB maps them back to 8 bits.
+var (
+	sRGBToLinear = sync.OnceValue(func() []uint16 {
+		lut := make([]uint16, 1<<16)
+		for i := range lut {
+			lut[i] = uint16(math.Round(parametricCurve(sRGBCurve)(float64(i)/0xFFFF) * 0xFFFF))
+		}
+		return lut
+	})
+	linearToSRGB = sync.OnceValue(func() []uint8 {
+		encode := targetColorProfiles["srgb"].encode
+		lut := make([]uint8, 1<<16)
+		for i := range lut {
+			lut[i] = uint8(math.Round(min(max(encode(float64(i)/0xFFFF), 0), 1) * 0xFF))
+		}
+		return lut
+	})
+)
+
+// toLinearLight copies the sr part of src into a premultiplied 16-bit buffer holding linear light values.
+func toLinearLight(src image.Image, sr image.Rectangle) *image.RGBA64 {
+	lut := sRGBToLinear()
+	dst := image.NewRGBA64(image.Rect(0, 0, sr.Dx(), sr.Dy()))
+
+	for y := range sr.Dy() {
+		row := dst.Pix[y*dst.Stride:]
+		for x := range sr.Dx() {
+			r, g, b, a := src.At(sr.Min.X+x, sr.Min.Y+y).RGBA()
+			if a == 0 {
+				continue
+			}
+			pix := row[x*8 : x*8+8]
+			for i, c := range []uint32{r, g, b} {
+				// the curve applies to straight color values, so alpha is taken out and put back around it
+				linear := uint32(lut[c*0xFFFF/a]) * a / 0xFFFF
+				pix[i*2], pix[i*2+1] = uint8(linear>>8), uint8(linear)
+			}
+			pix[6], pix[7] = uint8(a>>8), uint8(a)
+		}
+	}
+
+	return dst
+}
+
+// fromLinearLight encodes a premultiplied linear light buffer back into 8-bit sRGB.
+func fromLinearLight(src *image.RGBA64) *image.RGBA {
+	lut := linearToSRGB()
+	dst := image.NewRGBA(src.Rect)
+
+	for i, j := 0, 0; i+8 <= len(src.Pix); i, j = i+8, j+4 {
+		a := uint32(src.Pix[i+6])<<8 | uint32(src.Pix[i+7])
+		if a == 0 {
+			continue
+		}
+		a8 := a >> 8
+		for c := range 3 {
+			v := uint32(src.Pix[i+c*2])<<8 | uint32(src.Pix[i+c*2+1])
+			dst.Pix[j+c] = uint8(uint32(lut[min(v*0xFFFF/a, 0xFFFF)]) * a8 / 0xFF)
+		}
+		dst.Pix[j+3] = uint8(a8)
+	}
+
+	return dst
+}
@@ -0,0 +1,140 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strings"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/output"
+	"github.com/kolesa-team/go-webp/encoder"
+	"github.com/kolesa-team/go-webp/webp"
+)
+
+var _ Converter = (*LqipConverter)(nil)
+
+// LqipConverter writes a tiny, heavily compressed image as a data URI ready to be inlined into pages.
+type LqipConverter struct {
+	size          sizeOptions
+	decodeOptions decodeOptions
+	format        string
+	quality       int
+	qualitySearch *qualitySearch
+	outputClient  output.OutputClient
+}
+
+func NewLqipConverter(cfg *config.ConverterConfig) (Converter, error) {
+	if cfg.Type != "lqip" {
+		return nil, fmt.Errorf("invalid storage type for LqipConverter")
+	}
+	lqipCfg := cfg.Config.(*config.LqipConfig)
+
+	if lqipCfg.Animation.Mode == "keep" {
+		return nil, fmt.Errorf("keeping animation is only supported for webp output")
+	}
+	if (cfg.Metadata.Mode != "" && cfg.Metadata.Mode != "strip") || cfg.ColorProfile.Embed {
+		return nil, fmt.Errorf("metadata is not supported for lqip output")
+	}
+	if cfg.ColorProfile.Target != "" && cfg.ColorProfile.Target != "srgb" {
+		return nil, fmt.Errorf("lqip output can't embed a color profile, so it only supports the srgb target")
+	}
+
+	// placeholders are meant to be tiny, so an unset size falls back to 32px wide instead of the original size
+	sizeCfg := lqipCfg.Size
+	if sizeCfg.MaxWidth == 0 && sizeCfg.MaxHeight == 0 {
+		sizeCfg.MaxWidth = 32
+	}
+	size, err := newSizeOptions(sizeCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// MaxBytes caps the length of the data URI, searching qualities up to Quality
+	qualitySearch, err := newQualitySearch(lqipCfg.Quality, lqipCfg.MaxBytes, 1, lqipCfg.Quality, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	decodeOptions, err := newDecodeOptions(cfg, lqipCfg.Animation, lqipCfg.IgnoreOrientation)
+	if err != nil {
+		return nil, err
+	}
+
+	outputClient, err := output.NewOutputClientMap[cfg.Output.Storage.Type](&cfg.Output)
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize output client: %w", err)
+	}
+
+	format := lqipCfg.Format
+	if format == "" {
+		format = "webp"
+	}
+
+	return &LqipConverter{size, decodeOptions, format, lqipCfg.Quality, qualitySearch, outputClient}, nil
+}
+
+func (p *LqipConverter) Process(source *Source, outputName string) error {
+	inputMetadata := source.Metadata
+	decoded, err := source.decode(p.decodeOptions)
+	if err != nil {
+		return err
+	}
+	meta := decoded.meta
+	dst := p.size.resizeShared(decoded, focalPoint(inputMetadata, meta))
+
+	encode := func(quality int) ([]byte, error) {
+		return p.encode(dst, quality)
+	}
+	dataURI, misc, err := p.qualitySearch.encode(outputName, p.quality, encode, nil)
+	if err != nil {
+		return err
+	}
+
+	writer, err := p.outputClient.GetWriter(outputName, inputMetadata, "text/plain", misc)
+	if err != nil {
+		return fmt.Errorf("fail to initialize writer for output: %w", err)
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(dataURI)
+	return err
+}
+
+func (p *LqipConverter) encode(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	switch p.format {
+	case "jpeg":
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+	default:
+		opts, err := encoder.NewLossyEncoderOptions(encoder.PresetPhoto, float32(quality))
+		if err != nil {
+			return nil, fmt.Errorf("create webp encoder options: %w", err)
+		}
+		if err := webp.Encode(&buf, img, opts); err != nil {
+			return nil, err
+		}
+	}
+	return []byte("data:image/" + p.format + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+func (p *LqipConverter) DeductOutputPath(inputPath string) string {
+	pathParts := strings.Split(inputPath, ".")
+	if len(pathParts) < 2 {
+		return inputPath + ".txt"
+	}
+	pathParts[len(pathParts)-1] = "txt"
+	return strings.Join(pathParts, ".")
+}
+
+func (p *LqipConverter) ReadMetadata(path string) (*output.MetadataStruct, error) {
+	return p.outputClient.ReadMetadata(path)
+}
+
+func (p *LqipConverter) IsMissing(path string) bool {
+	return p.outputClient.IsMissing(path)
+}
@@ -0,0 +1,370 @@
+package converter
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"slices"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+)
+
+var (
+	xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader = []byte("ICC_PROFILE\x00")
+)
+
+const (
+	tiffTagXMP = 0x02BC
+	tiffTagICC = 0x8773
+
+	pngXMPKeyword = "XML:com.adobe.xmp"
+	// jpegMaxSegment is the largest payload of a JPEG marker segment
+	jpegMaxSegment = 65533
+)
+
+// imageMetadata holds the metadata blocks of an input, in the form they are embedded into outputs.
+type imageMetadata struct {
+	// exif is a TIFF structure, without the JPEG "Exif" prefix
+	exif []byte
+	xmp  []byte
+	icc  []byte
+	// fromTiff is set when exif is the whole TIFF-based input file rather than a dedicated EXIF block
+	fromTiff bool
+	// oriented is set when the pixels were turned upright, so the EXIF orientation no longer applies
+	oriented bool
+	// unconverted is set when the pixels were left in a profile which couldn't be parsed, so icc has to stay with them
+	unconverted bool
+}
+
+func (m *imageMetadata) empty() bool {
+	return m == nil || (len(m.exif) == 0 && len(m.xmp) == 0 && len(m.icc) == 0)
+}
+
+// extractMetadata collects EXIF, XMP and ICC blocks from JPEG, PNG, WebP and TIFF-based files.
+func extractMetadata(data []byte) *imageMetadata {
+	meta := &imageMetadata{exif: extractExif(data)}
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		iccChunks := map[byte][]byte{}
+		for _, segment := range jpegSegments(data) {
+			switch {
+			case segment.marker == 0xE1 && bytes.HasPrefix(segment.data, xmpHeader):
+				meta.xmp = segment.data[len(xmpHeader):]
+			case segment.marker == 0xE2 && bytes.HasPrefix(segment.data, iccHeader) && len(segment.data) > len(iccHeader)+2:
+				iccChunks[segment.data[len(iccHeader)]] = segment.data[len(iccHeader)+2:]
+			}
+		}
+		for seq := 1; seq <= len(iccChunks); seq++ {
+			chunk, ok := iccChunks[byte(seq)]
+			if !ok {
+				meta.icc = nil
+				break
+			}
+			meta.icc = append(meta.icc, chunk...)
+		}
+	case bytes.HasPrefix(data, pngHeader):
+		for _, chunk := range pngChunks(data) {
+			switch chunk.fourCC {
+			case "iCCP":
+				if _, compressed, ok := bytes.Cut(chunk.data, []byte{0}); ok && len(compressed) > 1 {
+					meta.icc = inflate(compressed[1:])
+				}
+			case "iTXt":
+				if xmp, ok := pngXMP(chunk.data); ok {
+					meta.xmp = xmp
+				}
+			}
+		}
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		chunks, _ := parseWebpContainer(data)
+		for _, chunk := range chunks {
+			switch chunk.fourCC {
+			case "XMP ":
+				meta.xmp = chunk.data
+			case "ICCP":
+				meta.icc = chunk.data
+			}
+		}
+	default:
+		if t, err := parseTiff(data); err == nil {
+			meta.fromTiff = true
+			if entries, _, err := t.readIFD(t.firstIFD()); err == nil {
+				if entry, ok := findTiffEntry(entries, tiffTagXMP); ok {
+					meta.xmp = entry.value
+				}
+				if entry, ok := findTiffEntry(entries, tiffTagICC); ok {
+					meta.icc = entry.value
+				}
+			}
+		}
+	}
+
+	return meta
+}
+
+func inflate(compressed []byte) []byte {
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// pngXMP returns the text of an iTXt chunk if it holds XMP.
+func pngXMP(data []byte) ([]byte, bool) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || string(keyword) != pngXMPKeyword || len(rest) < 2 {
+		return nil, false
+	}
+	compressed := rest[0] == 1
+	// skip the compression method, the language tag and the translated keyword
+	_, rest, ok = bytes.Cut(rest[2:], []byte{0})
+	if !ok {
+		return nil, false
+	}
+	_, text, ok := bytes.Cut(rest, []byte{0})
+	if !ok {
+		return nil, false
+	}
+	if compressed {
+		text = inflate(text)
+	}
+	return text, text != nil
+}
+
+// metadataPolicy decides which metadata of an input ends up in the output.
+type metadataPolicy struct {
+	mode string
+	tags map[exifTag]struct{}
+	xmp  bool
+	icc  bool
+	// embedProfile forces the color profile into the output, whatever the mode is. Pixels converted to a target
+	// other than sRGB always get it, as viewers would take them for sRGB otherwise.
+	embedProfile bool
+}
+
+func newMetadataPolicy(converterCfg *config.ConverterConfig) (*metadataPolicy, error) {
+	cfg := converterCfg.Metadata
+	target := converterCfg.ColorProfile.Target
+	embedProfile := converterCfg.ColorProfile.Embed || (target != "" && target != "srgb")
+	policy := &metadataPolicy{mode: cfg.Mode, tags: map[exifTag]struct{}{}, embedProfile: embedProfile}
+
+	switch cfg.Mode {
+	case "", "strip":
+		policy.mode = "strip"
+	case "keep":
+		policy.xmp, policy.icc = true, true
+	case "whitelist":
+		for _, name := range cfg.Tags {
+			switch name {
+			case "XMP":
+				policy.xmp = true
+			case "ICC":
+				policy.icc = true
+			default:
+				tag, ok := exifTagNames[name]
+				if !ok {
+					return nil, fmt.Errorf("unknown metadata tag: %s", name)
+				}
+				policy.tags[tag] = struct{}{}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported metadata mode: %s", cfg.Mode)
+	}
+
+	return policy, nil
+}
+
+// apply returns the metadata to embed into the output, or nil if there is none.
+func (p *metadataPolicy) apply(meta *imageMetadata) *imageMetadata {
+	if meta == nil {
+		meta = &imageMetadata{}
+	}
+
+	result := &imageMetadata{}
+	if p.xmp {
+		result.xmp = meta.xmp
+	}
+	if p.icc || p.embedProfile || meta.unconverted {
+		result.icc = meta.icc
+	}
+	// inputs without a profile are decoded as sRGB, so that's what gets embedded for them
+	if p.embedProfile && len(result.icc) == 0 {
+		result.icc = targetColorProfiles["srgb"].icc
+	}
+
+	if p.mode != "strip" && len(meta.exif) > 0 {
+		switch {
+		case p.mode == "whitelist":
+			result.exif = rebuildExif(meta.exif, func(tag exifTag) bool {
+				_, ok := p.tags[tag]
+				return ok
+			}, meta.oriented)
+		case meta.fromTiff:
+			result.exif = rebuildExif(meta.exif, func(tag exifTag) bool {
+				if tag.ifd != exifIFD0 {
+					return true
+				}
+				_, ok := exifDescriptiveIFD0Tags[tag.tag]
+				return ok
+			}, meta.oriented)
+		case meta.oriented:
+			result.exif = resetExifOrientation(meta.exif)
+		default:
+			result.exif = meta.exif
+		}
+	}
+
+	if result.empty() {
+		return nil
+	}
+	return result
+}
+
+// writeJpegWithMetadata writes an encoded JPEG, inserting the metadata as APP segments right after SOI.
+func writeJpegWithMetadata(w io.Writer, encoded []byte, meta *imageMetadata) error {
+	if meta.empty() || !bytes.HasPrefix(encoded, []byte{0xFF, 0xD8}) {
+		_, err := w.Write(encoded)
+		return err
+	}
+
+	appendSegment := func(buf []byte, marker byte, parts ...[]byte) []byte {
+		length := 2
+		for _, part := range parts {
+			length += len(part)
+		}
+		buf = append(buf, 0xFF, marker)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+		for _, part := range parts {
+			buf = append(buf, part...)
+		}
+		return buf
+	}
+
+	buf := []byte{0xFF, 0xD8}
+	if len(meta.exif) > 0 {
+		if len(exifHeader)+len(meta.exif) > jpegMaxSegment {
+			slog.Warn("skip exif metadata too large for a jpeg segment", slog.Int("size_bytes", len(meta.exif)))
+		} else {
+			buf = appendSegment(buf, 0xE1, exifHeader, meta.exif)
+		}
+	}
+	if len(meta.xmp) > 0 {
+		if len(xmpHeader)+len(meta.xmp) > jpegMaxSegment {
+			slog.Warn("skip xmp metadata too large for a jpeg segment", slog.Int("size_bytes", len(meta.xmp)))
+		} else {
+			buf = appendSegment(buf, 0xE1, xmpHeader, meta.xmp)
+		}
+	}
+	if len(meta.icc) > 0 {
+		chunkSize := jpegMaxSegment - len(iccHeader) - 2
+		chunks := slices.Collect(slices.Chunk(meta.icc, chunkSize))
+		if len(chunks) > 255 {
+			slog.Warn("skip icc profile too large for jpeg segments", slog.Int("size_bytes", len(meta.icc)))
+		} else {
+			for i, chunk := range chunks {
+				buf = appendSegment(buf, 0xE2, iccHeader, []byte{byte(i + 1), byte(len(chunks))}, chunk)
+			}
+		}
+	}
+
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	_, err := w.Write(encoded[2:])
+	return err
+}
+
+// writeWebpWithMetadata writes an encoded WebP, converting it to the extended format with ICCP, EXIF and XMP chunks.
+func writeWebpWithMetadata(w io.Writer, encoded []byte, meta *imageMetadata, width, height int) error {
+	if meta.empty() {
+		_, err := w.Write(encoded)
+		return err
+	}
+
+	chunks, err := parseWebpContainer(encoded)
+	if err != nil {
+		return fmt.Errorf("parse encoded webp: %w", err)
+	}
+
+	var flags byte
+	if len(chunks) > 0 && chunks[0].fourCC == "VP8X" && len(chunks[0].data) >= 10 {
+		flags = chunks[0].data[0]
+		width = readUint24(chunks[0].data[4:7]) + 1
+		height = readUint24(chunks[0].data[7:10]) + 1
+		chunks = chunks[1:]
+	}
+	for _, chunk := range chunks {
+		if chunk.fourCC == "ALPH" || (chunk.fourCC == "VP8L" && len(chunk.data) >= 5 && chunk.data[4]&0x10 != 0) {
+			flags |= vp8xFlagAlpha
+		}
+	}
+
+	result := []riffChunk{{}}
+	if len(meta.icc) > 0 {
+		flags |= vp8xFlagICC
+		result = append(result, riffChunk{"ICCP", meta.icc})
+	}
+	result = append(result, chunks...)
+	if len(meta.exif) > 0 {
+		flags |= vp8xFlagEXIF
+		result = append(result, riffChunk{"EXIF", meta.exif})
+	}
+	if len(meta.xmp) > 0 {
+		flags |= vp8xFlagXMP
+		result = append(result, riffChunk{"XMP ", meta.xmp})
+	}
+	result[0] = vp8xChunk(flags, width, height)
+
+	return writeWebpContainer(w, result)
+}
+
+// writePngWithMetadata writes an encoded PNG, inserting iCCP, eXIf and iTXt chunks right after IHDR.
+func writePngWithMetadata(w io.Writer, encoded []byte, meta *imageMetadata) error {
+	ihdrEnd := len(pngHeader) + 8 + 13 + 4
+	if meta.empty() || !bytes.HasPrefix(encoded, pngHeader) || len(encoded) < ihdrEnd {
+		_, err := w.Write(encoded)
+		return err
+	}
+
+	appendChunk := func(buf []byte, fourCC string, data []byte) []byte {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+		start := len(buf)
+		buf = append(buf, fourCC...)
+		buf = append(buf, data...)
+		return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:]))
+	}
+
+	buf := slices.Clone(encoded[:ihdrEnd])
+	if len(meta.icc) > 0 {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(meta.icc)
+		zw.Close()
+		buf = appendChunk(buf, "iCCP", append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...))
+	}
+	if len(meta.exif) > 0 {
+		buf = appendChunk(buf, "eXIf", meta.exif)
+	}
+	if len(meta.xmp) > 0 {
+		buf = appendChunk(buf, "iTXt", append([]byte(pngXMPKeyword+"\x00\x00\x00\x00\x00"), meta.xmp...))
+	}
+
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	_, err := w.Write(encoded[ihdrEnd:])
+	return err
+}
@@ -0,0 +1,132 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/kolesa-team/go-webp/encoder"
+	"github.com/kolesa-team/go-webp/webp"
+)
+
+func testMetadataImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 7, 5))
+	for y := range 5 {
+		for x := range 7 {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 36), uint8(y * 50), 128, 255})
+		}
+	}
+	return img
+}
+
+func TestWriteWithMetadata(t *testing.T) {
+	img := testMetadataImage()
+	exif := buildTestExif(binary.BigEndian, []tiffEntry{testExifMake, testExifOrientation(binary.BigEndian, 1)}, []tiffEntry{testExifLensModel}, nil)
+	// odd sizes make RIFF writers pad their chunks
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`)
+	icc := targetColorProfiles["display-p3"].icc
+	// JPEG splits profiles over several APP2 segments
+	largeICC := bytes.Repeat([]byte{0x5A}, 2*jpegMaxSegment+1)
+
+	encoders := []struct {
+		name   string
+		encode func(img image.Image) ([]byte, error)
+		write  func(buf *bytes.Buffer, encoded []byte, meta *imageMetadata) error
+		decode func(data []byte) (image.Image, error)
+	}{
+		{
+			name: "jpeg",
+			encode: func(img image.Image) ([]byte, error) {
+				var buf bytes.Buffer
+				err := jpeg.Encode(&buf, img, nil)
+				return buf.Bytes(), err
+			},
+			write: func(buf *bytes.Buffer, encoded []byte, meta *imageMetadata) error {
+				return writeJpegWithMetadata(buf, encoded, meta)
+			},
+			decode: func(data []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(data)) },
+		},
+		{
+			name: "png",
+			encode: func(img image.Image) ([]byte, error) {
+				var buf bytes.Buffer
+				err := png.Encode(&buf, img)
+				return buf.Bytes(), err
+			},
+			write: func(buf *bytes.Buffer, encoded []byte, meta *imageMetadata) error {
+				return writePngWithMetadata(buf, encoded, meta)
+			},
+			decode: func(data []byte) (image.Image, error) { return png.Decode(bytes.NewReader(data)) },
+		},
+		{
+			name: "webp",
+			encode: func(img image.Image) ([]byte, error) {
+				opts, err := encoder.NewLosslessEncoderOptions(encoder.PresetDefault, 0)
+				if err != nil {
+					return nil, err
+				}
+				var buf bytes.Buffer
+				err = webp.Encode(&buf, img, opts)
+				return buf.Bytes(), err
+			},
+			write: func(buf *bytes.Buffer, encoded []byte, meta *imageMetadata) error {
+				return writeWebpWithMetadata(buf, encoded, meta, 7, 5)
+			},
+			decode: func(data []byte) (image.Image, error) { return webp.Decode(bytes.NewReader(data), nil) },
+		},
+	}
+
+	for _, enc := range encoders {
+		for _, tc := range []struct {
+			name string
+			meta *imageMetadata
+		}{
+			{"none", nil},
+			{"exif", &imageMetadata{exif: exif}},
+			{"all", &imageMetadata{exif: exif, xmp: xmp, icc: icc}},
+			{"odd sizes", &imageMetadata{exif: exif[:len(exif)-1], xmp: xmp[:len(xmp)-2], icc: icc[:len(icc)-1]}},
+			{"large icc", &imageMetadata{icc: largeICC}},
+		} {
+			t.Run(enc.name+"/"+tc.name, func(t *testing.T) {
+				encoded, err := enc.encode(img)
+				if err != nil {
+					t.Fatalf("encode: %v", err)
+				}
+				var buf bytes.Buffer
+				if err := enc.write(&buf, encoded, tc.meta); err != nil {
+					t.Fatalf("write with metadata: %v", err)
+				}
+
+				decoded, err := enc.decode(buf.Bytes())
+				if err != nil {
+					t.Fatalf("decode output: %v", err)
+				}
+				if decoded.Bounds() != img.Bounds() {
+					t.Errorf("got bounds %v, want %v", decoded.Bounds(), img.Bounds())
+				}
+
+				want := tc.meta
+				if want == nil {
+					want = &imageMetadata{}
+				}
+				got := extractMetadata(buf.Bytes())
+				for _, block := range []struct {
+					name      string
+					got, want []byte
+				}{
+					{"exif", got.exif, want.exif},
+					{"xmp", got.xmp, want.xmp},
+					{"icc", got.icc, want.icc},
+				} {
+					if !bytes.Equal(block.got, block.want) {
+						t.Errorf("%s: got %d bytes, want %d", block.name, len(block.got), len(block.want))
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package converter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+)
+
+// overlay draws a watermark image or a text caption over outputs.
+type overlay struct {
+	watermark image.Image
+	font      *opentype.Font
+	text      *template.Template
+	color     color.Color
+	position  string
+	// margin and scale are relative to the output width
+	margin  float64
+	scale   float64
+	opacity float64
+}
+
+// overlayFields are the fields available to caption templates, e.g. "© {{.Captured.Year}} {{.Artist}}".
+type overlayFields struct {
+	// Name is the file name of the input, without its directory
+	Name string
+	// Captured is the EXIF capture time, falling back to the time the input was created
+	Captured  time.Time
+	Artist    string
+	Copyright string
+}
+
+func newOverlay(cfg *config.OverlayConfig) (*overlay, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if (cfg.Image == nil) == (cfg.Text == nil) {
+		return nil, fmt.Errorf("overlay should have exactly one of image and text")
+	}
+
+	o := &overlay{position: cfg.Position, margin: cfg.Margin, scale: cfg.Scale, opacity: cfg.Opacity}
+	switch cfg.Position {
+	case "":
+		o.position = "bottom-right"
+	case "top-left", "top-right", "bottom-left", "bottom-right", "center":
+	default:
+		return nil, fmt.Errorf("unsupported overlay position: %s", cfg.Position)
+	}
+	if cfg.Scale <= 0 || cfg.Scale > 1 {
+		return nil, fmt.Errorf("overlay scale should be between 0 and 1, got %g", cfg.Scale)
+	}
+	if cfg.Opacity == 0 {
+		o.opacity = 1
+	} else if cfg.Opacity < 0 || cfg.Opacity > 1 {
+		return nil, fmt.Errorf("overlay opacity should be between 0 and 1, got %g", cfg.Opacity)
+	}
+
+	if cfg.Image != nil {
+		watermark, err := readWatermark(cfg.Image)
+		if err != nil {
+			return nil, fmt.Errorf("read watermark: %w", err)
+		}
+		o.watermark = watermark
+		return o, nil
+	}
+
+	text, err := template.New("overlay").Parse(cfg.Text.Template)
+	if err != nil {
+		return nil, fmt.Errorf("parse overlay template: %w", err)
+	}
+	o.text = text
+
+	fontData := goregular.TTF
+	if cfg.Text.FontPath != "" {
+		if fontData, err = os.ReadFile(cfg.Text.FontPath); err != nil {
+			return nil, fmt.Errorf("read font: %w", err)
+		}
+	}
+	if o.font, err = opentype.Parse(fontData); err != nil {
+		return nil, fmt.Errorf("parse font: %w", err)
+	}
+
+	o.color = color.White
+	if cfg.Text.Color != "" {
+		if o.color, err = parseHexColor(cfg.Text.Color); err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+// readWatermark decodes the watermark image from its input storage.
+func readWatermark(cfg *config.OverlayImageConfig) (image.Image, error) {
+	inputClient, err := input.NewInputClientMap[cfg.Storage.Type](&config.InputConfig{Storage: cfg.Storage})
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize input client: %w", err)
+	}
+	inputMetadata, err := inputClient.ReadMetadata(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+	reader, err := inputClient.GetReader(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return decodeData(inputMetadata, data, decodeOptions{})
+}
+
+// drawer returns a function drawing the overlay over images of an input, with its caption resolved once,
+// so it can be applied to every frame of an animation. A nil overlay leaves images as they are.
+func (o *overlay) drawer(inputMetadata *input.MetadataStruct, meta *imageMetadata) (func(image.Image) image.Image, error) {
+	if o == nil {
+		return func(img image.Image) image.Image { return img }, nil
+	}
+
+	caption := ""
+	if o.text != nil {
+		var err error
+		if caption, err = o.caption(inputMetadata, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(img image.Image) image.Image {
+		return o.draw(img, caption)
+	}, nil
+}
+
+func (o *overlay) draw(img image.Image, caption string) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+
+	var layer image.Image
+	if o.watermark != nil {
+		wb := o.watermark.Bounds()
+		width := max(1, int(o.scale*float64(b.Dx())+0.5))
+		height := max(1, wb.Dy()*width/wb.Dx())
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(scaled, scaled.Rect, o.watermark, wb, draw.Over, nil)
+		layer = scaled
+	} else {
+		var err error
+		if layer, err = o.fitText(caption, max(1, int(o.scale*float64(b.Dx())+0.5))); err != nil {
+			slog.Warn("skip overlay text", slog.String("error", err.Error()))
+			return img
+		}
+	}
+
+	lb := layer.Bounds()
+	margin := int(o.margin*float64(b.Dx()) + 0.5)
+	var at image.Point
+	switch o.position {
+	case "top-left":
+		at = image.Pt(margin, margin)
+	case "top-right":
+		at = image.Pt(b.Dx()-lb.Dx()-margin, margin)
+	case "bottom-left":
+		at = image.Pt(margin, b.Dy()-lb.Dy()-margin)
+	case "center":
+		at = image.Pt((b.Dx()-lb.Dx())/2, (b.Dy()-lb.Dy())/2)
+	default:
+		at = image.Pt(b.Dx()-lb.Dx()-margin, b.Dy()-lb.Dy()-margin)
+	}
+
+	mask := image.NewUniform(color.Alpha{uint8(o.opacity*255 + 0.5)})
+	draw.DrawMask(dst, lb.Sub(lb.Min).Add(at), layer, lb.Min, mask, image.Point{}, draw.Over)
+	return dst
+}
+
+func (o *overlay) caption(inputMetadata *input.MetadataStruct, meta *imageMetadata) (string, error) {
+	fields := overlayFields{Name: path.Base(inputMetadata.Name), Captured: inputMetadata.FirstCreated}
+	if meta != nil && len(meta.exif) > 0 {
+		for _, name := range []string{"DateTimeOriginal", "DateTime"} {
+			if captured, err := time.Parse("2006:01:02 15:04:05", exifString(meta.exif, exifTagNames[name])); err == nil {
+				fields.Captured = captured
+				break
+			}
+		}
+		fields.Artist = exifString(meta.exif, exifTagNames["Artist"])
+		fields.Copyright = exifString(meta.exif, exifTagNames["Copyright"])
+	}
+
+	var caption strings.Builder
+	if err := o.text.Execute(&caption, fields); err != nil {
+		return "", fmt.Errorf("execute overlay template: %w", err)
+	}
+	return caption.String(), nil
+}
+
+// fitText draws a single line of text sized so it is width pixels wide, the same way watermarks are scaled.
+func (o *overlay) fitText(text string, width int) (image.Image, error) {
+	// text width grows about linearly with the font size, so it is measured at a reference size and corrected
+	// once more at the estimated size, where hinting rounds glyph advances differently
+	size := 100.0
+	for range 2 {
+		measured, err := o.measureText(text, size)
+		if err != nil {
+			return nil, err
+		}
+		if measured <= 0 {
+			return nil, fmt.Errorf("overlay text is empty")
+		}
+		size *= float64(width) / measured
+	}
+	return o.renderText(text, size)
+}
+
+// measureText returns the width in pixels of a single line of text with the given font size.
+func (o *overlay) measureText(text string, size float64) (float64, error) {
+	face, err := o.newFace(size)
+	if err != nil {
+		return 0, err
+	}
+	defer face.Close()
+	return float64(font.MeasureString(face, text)) / 64, nil
+}
+
+func (o *overlay) newFace(size float64) (font.Face, error) {
+	face, err := opentype.NewFace(o.font, &opentype.FaceOptions{Size: max(size, 1), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("create font face: %w", err)
+	}
+	return face, nil
+}
+
+// renderText draws a single line of text with the given font size in pixels onto a transparent image of its size.
+func (o *overlay) renderText(text string, size float64) (image.Image, error) {
+	face, err := o.newFace(size)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	width := max(1, font.MeasureString(face, text).Ceil())
+	height := max(1, (metrics.Ascent + metrics.Descent).Ceil())
+
+	layer := image.NewRGBA(image.Rect(0, 0, width, height))
+	drawer := &font.Drawer{Dst: layer, Src: image.NewUniform(o.color), Face: face, Dot: fixed.Point26_6{Y: metrics.Ascent}}
+	drawer.DrawString(text)
+	return layer, nil
+}
@@ -0,0 +1,182 @@
+package converter
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/output"
+)
+
+var _ Converter = (*PaletteConverter)(nil)
+
+// paletteIterations is the number of k-means refinements run over the median cut palette.
+const paletteIterations = 8
+
+// PaletteConverter writes a JSON object with the dominant color and the palette of an image.
+type PaletteConverter struct {
+	colors        int
+	decodeOptions decodeOptions
+	outputClient  output.OutputClient
+}
+
+type paletteOutput struct {
+	Dominant string         `json:"Dominant"`
+	Palette  []paletteColor `json:"Palette"`
+}
+
+type paletteColor struct {
+	Color string `json:"Color"`
+	// Share is the fraction of visible pixels closest to this color
+	Share float64 `json:"Share"`
+}
+
+func NewPaletteConverter(cfg *config.ConverterConfig) (Converter, error) {
+	if cfg.Type != "palette" {
+		return nil, fmt.Errorf("invalid storage type for PaletteConverter")
+	}
+	paletteCfg := cfg.Config.(*config.PaletteConfig)
+
+	if paletteCfg.Animation.Mode == "keep" {
+		return nil, fmt.Errorf("keeping animation is only supported for webp output")
+	}
+
+	colors := 5
+	if paletteCfg.Colors != 0 {
+		colors = paletteCfg.Colors
+	}
+
+	decodeOptions, err := newDecodeOptions(cfg, paletteCfg.Animation, paletteCfg.IgnoreOrientation)
+	if err != nil {
+		return nil, err
+	}
+
+	outputClient, err := output.NewOutputClientMap[cfg.Output.Storage.Type](&cfg.Output)
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize output client: %w", err)
+	}
+
+	return &PaletteConverter{colors, decodeOptions, outputClient}, nil
+}
+
+func (p *PaletteConverter) Process(source *Source, outputName string) error {
+	inputMetadata := source.Metadata
+	decoded, err := source.decode(p.decodeOptions)
+	if err != nil {
+		return err
+	}
+	src := decoded.img
+
+	out := paletteOutput{Palette: []paletteColor{}}
+	for _, c := range extractPalette(placeholderSource(src), p.colors) {
+		out.Palette = append(out.Palette, paletteColor{Color: hexColor(c.color), Share: c.share})
+	}
+	if len(out.Palette) > 0 {
+		out.Dominant = out.Palette[0].Color
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(out); err != nil {
+		return err
+	}
+
+	writer, err := p.outputClient.GetWriter(outputName, inputMetadata, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("fail to initialize writer for output: %w", err)
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(buf.Bytes())
+	return err
+}
+
+type weightedColor struct {
+	color color.NRGBA
+	share float64
+}
+
+// extractPalette seeds k-means with the median cut palette of img and refines it over its visible pixels.
+// Colors are returned sorted by their share, the dominant one first.
+func extractPalette(img *image.NRGBA, k int) []weightedColor {
+	var pixels []color.NRGBA
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i+3] > 0 {
+			pixels = append(pixels, color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], 255})
+		}
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	centers := make([][3]float64, 0, k)
+	for _, c := range medianCutPalette(img, k) {
+		seed := c.(color.NRGBA)
+		centers = append(centers, [3]float64{float64(seed.R), float64(seed.G), float64(seed.B)})
+	}
+
+	counts := make([]int, len(centers))
+	for range paletteIterations {
+		sums := make([][3]float64, len(centers))
+		clear(counts)
+		for _, px := range pixels {
+			best, bestDistance := 0, -1.0
+			for j, c := range centers {
+				dr, dg, db := float64(px.R)-c[0], float64(px.G)-c[1], float64(px.B)-c[2]
+				if d := dr*dr + dg*dg + db*db; bestDistance < 0 || d < bestDistance {
+					best, bestDistance = j, d
+				}
+			}
+			counts[best]++
+			sums[best][0] += float64(px.R)
+			sums[best][1] += float64(px.G)
+			sums[best][2] += float64(px.B)
+		}
+		for j := range centers {
+			if counts[j] > 0 {
+				centers[j] = [3]float64{sums[j][0] / float64(counts[j]), sums[j][1] / float64(counts[j]), sums[j][2] / float64(counts[j])}
+			}
+		}
+	}
+
+	palette := make([]weightedColor, 0, len(centers))
+	for j, c := range centers {
+		if counts[j] == 0 {
+			continue
+		}
+		palette = append(palette, weightedColor{
+			color: color.NRGBA{uint8(c[0] + 0.5), uint8(c[1] + 0.5), uint8(c[2] + 0.5), 255},
+			share: float64(counts[j]) / float64(len(pixels)),
+		})
+	}
+	slices.SortStableFunc(palette, func(a, b weightedColor) int {
+		return cmp.Compare(b.share, a.share)
+	})
+	return palette
+}
+
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (p *PaletteConverter) DeductOutputPath(inputPath string) string {
+	pathParts := strings.Split(inputPath, ".")
+	if len(pathParts) < 2 {
+		return inputPath + ".palette.json"
+	}
+	pathParts[len(pathParts)-1] = "palette.json"
+	return strings.Join(pathParts, ".")
+}
+
+func (p *PaletteConverter) ReadMetadata(path string) (*output.MetadataStruct, error) {
+	return p.outputClient.ReadMetadata(path)
+}
+
+func (p *PaletteConverter) IsMissing(path string) bool {
+	return p.outputClient.IsMissing(path)
+}
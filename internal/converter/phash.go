@@ -0,0 +1,97 @@
+package converter
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"slices"
+
+	"golang.org/x/image/draw"
+)
+
+// PerceptualHash returns the 64-bit dHash or pHash of an input. Hashes are computed on the upright image,
+// so near-duplicates differ in only a few bits, and rotated re-exports of a photo still match.
+func PerceptualHash(source *Source, algorithm string) (uint64, error) {
+	decoded, err := source.decode(decodeOptions{colorProfile: targetColorProfiles["srgb"]})
+	if err != nil {
+		return 0, err
+	}
+	src := decoded.img
+
+	switch algorithm {
+	case "", "dhash":
+		return differenceHash(src), nil
+	case "phash":
+		return dctHash(src), nil
+	default:
+		return 0, fmt.Errorf("unsupported perceptual hash algorithm: %s", algorithm)
+	}
+}
+
+func grayscale(src image.Image, width, height int) *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(dst, dst.Rect, src, src.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// differenceHash sets a bit for every pixel of a 9x8 grayscale copy that is brighter than its right neighbour.
+func differenceHash(src image.Image) uint64 {
+	gray := grayscale(src, 9, 8)
+	var hash uint64
+	for y := range 8 {
+		for x := range 8 {
+			hash <<= 1
+			if gray.GrayAt(x, y).Y > gray.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// dctHash sets a bit for every one of the 8x8 lowest frequencies of a 32x32 grayscale copy above their median.
+func dctHash(src image.Image) uint64 {
+	const size, low = 32, 8
+	gray := grayscale(src, size, size)
+
+	var cosines [low][size]float64
+	for u := range low {
+		for x := range size {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+
+	// the separable DCT-II, first over rows and then over columns, only for the frequencies kept
+	var rows [size][low]float64
+	for y := range size {
+		for u := range low {
+			for x := range size {
+				rows[y][u] += float64(gray.GrayAt(x, y).Y) * cosines[u][x]
+			}
+		}
+	}
+	coefficients := make([]float64, 0, low*low)
+	for v := range low {
+		for u := range low {
+			sum := 0.0
+			for y := range size {
+				sum += rows[y][u] * cosines[v][y]
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	// the DC coefficient is the average brightness, so it is left out of the median
+	sorted := slices.Clone(coefficients[1:])
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for _, c := range coefficients {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
@@ -0,0 +1,222 @@
+package converter
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const blurhashCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// placeholderSource scales img down to at most 100x100, the largest size ThumbHash accepts, and returns its
+// straight (non-premultiplied) pixels. Placeholders only keep a handful of frequencies, so nothing is lost.
+func placeholderSource(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	scale := min(1, 100/float64(max(b.Dx(), b.Dy())))
+	dst := image.NewNRGBA(image.Rect(0, 0, max(1, int(float64(b.Dx())*scale+0.5)), max(1, int(float64(b.Dy())*scale+0.5))))
+	draw.BiLinear.Scale(dst, dst.Rect, img, b, draw.Src, nil)
+	return dst
+}
+
+func encodeBase83(value, length int) string {
+	var sb strings.Builder
+	for i := 1; i <= length; i++ {
+		digit := value / int(math.Pow(83, float64(length-i))) % 83
+		sb.WriteByte(blurhashCharacters[digit])
+	}
+	return sb.String()
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// encodeBlurHash computes the BlurHash (https://blurha.sh) of img with componentsX x componentsY components.
+func encodeBlurHash(img *image.NRGBA, componentsX, componentsY int) string {
+	toLinear := parametricCurve(sRGBCurve)
+	toSRGB := func(v float64) int {
+		return int(math.Round(min(max(targetColorProfiles["srgb"].encode(v), 0), 1) * 255))
+	}
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	linear := make([][3]float64, w*h)
+	for i := range linear {
+		for c := range 3 {
+			linear[i][c] = toLinear(float64(img.Pix[i*4+c]) / 255)
+		}
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := range componentsY {
+		for i := range componentsX {
+			normalization := 2.0
+			if i == 0 && j == 0 {
+				normalization = 1
+			}
+			var factor [3]float64
+			for y := range h {
+				for x := range w {
+					basis := normalization * math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) * math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+					for c := range 3 {
+						factor[c] += basis * linear[y*w+x][c]
+					}
+				}
+			}
+			for c := range 3 {
+				factor[c] /= float64(w * h)
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(encodeBase83((componentsX-1)+(componentsY-1)*9, 1))
+
+	maximumValue := 1.0
+	if len(factors) > 1 {
+		actualMaximum := 0.0
+		for _, factor := range factors[1:] {
+			for _, v := range factor {
+				actualMaximum = max(actualMaximum, math.Abs(v))
+			}
+		}
+		quantisedMaximum := int(max(0, min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		sb.WriteString(encodeBase83(quantisedMaximum, 1))
+	} else {
+		sb.WriteString(encodeBase83(0, 1))
+	}
+
+	dc := factors[0]
+	sb.WriteString(encodeBase83(toSRGB(dc[0])<<16+toSRGB(dc[1])<<8+toSRGB(dc[2]), 4))
+
+	for _, factor := range factors[1:] {
+		value := 0
+		for _, v := range factor {
+			quantised := int(max(0, min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+			value = value*19 + quantised
+		}
+		sb.WriteString(encodeBase83(value, 2))
+	}
+
+	return sb.String()
+}
+
+// jsRound rounds half up, same as Math.round in the ThumbHash reference implementation.
+func jsRound(v float64) int {
+	return int(math.Floor(v + 0.5))
+}
+
+// encodeThumbHash computes the ThumbHash (https://evanw.github.io/thumbhash/) of an image of at most 100x100,
+// following the reference implementation.
+func encodeThumbHash(img *image.NRGBA) []byte {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	n := w * h
+
+	var avgR, avgG, avgB, avgA float64
+	for i := range n {
+		alpha := float64(img.Pix[i*4+3]) / 255
+		avgR += alpha / 255 * float64(img.Pix[i*4])
+		avgG += alpha / 255 * float64(img.Pix[i*4+1])
+		avgB += alpha / 255 * float64(img.Pix[i*4+2])
+		avgA += alpha
+	}
+	if avgA > 0 {
+		avgR /= avgA
+		avgG /= avgA
+		avgB /= avgA
+	}
+
+	hasAlpha := avgA < float64(n)
+	lLimit := 7.0
+	if hasAlpha {
+		// fewer luminance bits are used when there's alpha
+		lLimit = 5
+	}
+	lx := max(1, jsRound(lLimit*float64(w)/float64(max(w, h))))
+	ly := max(1, jsRound(lLimit*float64(h)/float64(max(w, h))))
+
+	// the image is converted to LPQA, composited atop the average color
+	l, p, q, a := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range n {
+		alpha := float64(img.Pix[i*4+3]) / 255
+		r := avgR*(1-alpha) + alpha/255*float64(img.Pix[i*4])
+		g := avgG*(1-alpha) + alpha/255*float64(img.Pix[i*4+1])
+		b := avgB*(1-alpha) + alpha/255*float64(img.Pix[i*4+2])
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	encodeChannel := func(channel []float64, nx, ny int) (float64, []float64, float64) {
+		dc, scale := 0.0, 0.0
+		ac := []float64{}
+		fx := make([]float64, w)
+		for cy := range ny {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				for x := range w {
+					fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+				}
+				f := 0.0
+				for y := range h {
+					fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+					for x := range w {
+						f += channel[x+y*w] * fx[x] * fy
+					}
+				}
+				f /= float64(n)
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+
+	lDC, lAC, lScale := encodeChannel(l, max(3, lx), max(3, ly))
+	pDC, pAC, pScale := encodeChannel(p, 3, 3)
+	qDC, qAC, qScale := encodeChannel(q, 3, 3)
+
+	isLandscape := w > h
+	header24 := jsRound(63*lDC) | jsRound(31.5+31.5*pDC)<<6 | jsRound(31.5+31.5*qDC)<<12 | jsRound(31*lScale)<<18
+	header16 := lx | jsRound(63*pScale)<<3 | jsRound(63*qScale)<<9
+	if isLandscape {
+		header16 = ly | jsRound(63*pScale)<<3 | jsRound(63*qScale)<<9 | 1<<15
+	}
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+
+	channels := [][]float64{lAC, pAC, qAC}
+	if hasAlpha {
+		aDC, aAC, aScale := encodeChannel(a, 5, 5)
+		hash = append(hash, byte(jsRound(15*aDC)|jsRound(15*aScale)<<4))
+		channels = append(channels, aAC)
+	}
+
+	acStart, acIndex := len(hash), 0
+	for _, ac := range channels {
+		for _, f := range ac {
+			pos := acStart + acIndex>>1
+			if pos >= len(hash) {
+				hash = append(hash, 0)
+			}
+			hash[pos] |= byte(jsRound(15*f) << ((acIndex & 1) << 2))
+			acIndex++
+		}
+	}
+
+	return hash
+}
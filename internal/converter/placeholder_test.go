@@ -0,0 +1,66 @@
+package converter
+
+import (
+	"encoding/base64"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testPlaceholderImage is a pattern in which no frequency cancels out, as those would leave the hashes to
+// floating point noise. Alpha varies too, if asked.
+func testPlaceholderImage(width, height int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			a := 255
+			if alpha {
+				a = 255 - (x*19+y*23+29)%120
+			}
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x*37 + y*91 + x*y*13 + 29), uint8(x*71 + y*29 + x*x*7 + 87), uint8(x*53 + y*y*11 + 162), uint8(a)})
+		}
+	}
+	return img
+}
+
+// Expected hashes come from the reference implementations: the C encoder of BlurHash and the JavaScript
+// encoder of ThumbHash.
+func TestEncodeBlurHash(t *testing.T) {
+	solid := testSolidImage(image.Rect(0, 0, 6, 4), color.NRGBA{200, 100, 50, 255})
+	pattern := testPlaceholderImage(8, 6, false)
+
+	for _, tc := range []struct {
+		name                     string
+		img                      *image.NRGBA
+		componentsX, componentsY int
+		want                     string
+	}{
+		{"solid", solid, 4, 3, "LlM|T9=dfQ=d}XxFfQxFfQfQfQfQ"},
+		{"pattern", pattern, 4, 3, "LVH.f+l9o]^-%NRDP7kGnWRVRFtC"},
+		{"pattern dc only", pattern, 1, 1, "00H.f+"},
+		{"pattern max components", pattern, 9, 9, "|bH.f+k=ov?cTG%zP8-g*U%3RVO;j|JCoJZWM#w5nWVyRFs[W9S_w3rWT@^lNeRDxnN;b^KINYPKTBIqKIxBE*SwvOEMxa%|ajI[%4sqo^,tr?%1rZRUItX2;O,?IxIxX_%|ajI[%4sqo^,tr?%1TBIqKIxBE*SwvOEMxa"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := encodeBlurHash(tc.img, tc.componentsX, tc.componentsY); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEncodeThumbHash(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		img  *image.NRGBA
+		want string
+	}{
+		{"landscape", testPlaceholderImage(8, 6, false), "YPgFFYSlf1hTmVVs55NEZtMVAJe6"},
+		{"portrait with alpha", testPlaceholderImage(5, 7, true), "3veFDAYbnGnIA2leoRMChgqXjFmGaLz6Bw=="},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := base64.StdEncoding.EncodeToString(encodeThumbHash(tc.img)); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
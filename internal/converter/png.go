@@ -0,0 +1,148 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/output"
+)
+
+var _ Converter = (*PngConverter)(nil)
+
+type PngConverter struct {
+	size          sizeOptions
+	decodeOptions decodeOptions
+	overlay       *overlay
+	metadata      *metadataPolicy
+	maxColors     int
+	dithering     bool
+	encoder       *png.Encoder
+	outputClient  output.OutputClient
+}
+
+func NewPngConverter(cfg *config.ConverterConfig) (Converter, error) {
+	if cfg.Type != "png" {
+		return nil, fmt.Errorf("invalid storage type for PngConverter")
+	}
+	pngCfg := cfg.Config.(*config.PngConfig)
+
+	if pngCfg.Animation.Mode == "keep" {
+		return nil, fmt.Errorf("keeping animation is only supported for webp output")
+	}
+
+	metadata, err := newMetadataPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := newSizeOptions(pngCfg.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	decodeOptions, err := newDecodeOptions(cfg, pngCfg.Animation, pngCfg.IgnoreOrientation)
+	if err != nil {
+		return nil, err
+	}
+
+	overlay, err := newOverlay(cfg.Overlay)
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize overlay: %w", err)
+	}
+
+	outputClient, err := output.NewOutputClientMap[cfg.Output.Storage.Type](&cfg.Output)
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize output client: %w", err)
+	}
+
+	encoder := &png.Encoder{}
+	switch pngCfg.CompressionLevel {
+	case "", "Default":
+		encoder.CompressionLevel = png.DefaultCompression
+	case "NoCompression":
+		encoder.CompressionLevel = png.NoCompression
+	case "BestSpeed":
+		encoder.CompressionLevel = png.BestSpeed
+	case "BestCompression":
+		encoder.CompressionLevel = png.BestCompression
+	default:
+		return nil, fmt.Errorf("unsupported compression level: %s", pngCfg.CompressionLevel)
+	}
+
+	converter := &PngConverter{size: size, decodeOptions: decodeOptions, overlay: overlay, metadata: metadata, encoder: encoder, outputClient: outputClient}
+	if pngCfg.Palette != nil {
+		if pngCfg.Palette.MaxColors < 2 || pngCfg.Palette.MaxColors > 256 {
+			return nil, fmt.Errorf("palette size should be between 2 and 256 colors, got %d", pngCfg.Palette.MaxColors)
+		}
+		converter.maxColors = pngCfg.Palette.MaxColors
+		converter.dithering = pngCfg.Palette.Dithering
+	}
+
+	return converter, nil
+}
+
+func (p *PngConverter) Process(source *Source, outputName string) error {
+	inputMetadata := source.Metadata
+	decoded, err := source.decode(p.decodeOptions)
+	if err != nil {
+		return err
+	}
+	meta := decoded.meta
+
+	drawOverlay, err := p.overlay.drawer(inputMetadata, meta)
+	if err != nil {
+		return err
+	}
+
+	dst := drawOverlay(p.size.resizeShared(decoded, focalPoint(inputMetadata, meta)))
+	if p.maxColors > 0 {
+		paletted := image.NewPaletted(dst.Bounds(), medianCutPalette(dst, p.maxColors))
+		if p.dithering {
+			draw.FloydSteinberg.Draw(paletted, paletted.Rect, dst, dst.Bounds().Min)
+		} else {
+			draw.Draw(paletted, paletted.Rect, dst, dst.Bounds().Min, draw.Src)
+		}
+		dst = paletted
+	}
+
+	var buf bytes.Buffer
+	if err := p.encoder.Encode(&buf, dst); err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	if err := writePngWithMetadata(&data, buf.Bytes(), p.metadata.apply(meta)); err != nil {
+		return err
+	}
+
+	writer, err := p.outputClient.GetWriter(outputName, inputMetadata, "image/png", nil)
+	if err != nil {
+		return fmt.Errorf("fail to initialize writer for output: %w", err)
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(data.Bytes())
+	return err
+}
+
+func (p *PngConverter) DeductOutputPath(inputPath string) string {
+	pathParts := strings.Split(inputPath, ".")
+	if len(pathParts) < 2 {
+		return inputPath + ".png"
+	}
+	pathParts[len(pathParts)-1] = "png"
+	return strings.Join(pathParts, ".")
+}
+
+func (p *PngConverter) ReadMetadata(path string) (*output.MetadataStruct, error) {
+	return p.outputClient.ReadMetadata(path)
+}
+
+func (p *PngConverter) IsMissing(path string) bool {
+	return p.outputClient.IsMissing(path)
+}
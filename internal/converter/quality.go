@@ -0,0 +1,127 @@
+package converter
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+)
+
+// qualitySearch picks the quality of an output instead of a fixed one: either the highest quality whose encoding
+// fits into a byte budget, or the lowest quality whose SSIM against the resized image reaches a score.
+type qualitySearch struct {
+	maxBytes   int
+	minScore   float64
+	minQuality int
+	maxQuality int
+}
+
+// newQualitySearch returns nil when neither MaxBytes nor AutoQuality is set, in which case the fixed quality is used.
+func newQualitySearch(quality, maxBytes, minQuality, maxQuality int, autoQuality *config.AutoQualityConfig) (*qualitySearch, error) {
+	if maxBytes != 0 && autoQuality != nil {
+		return nil, fmt.Errorf("MaxBytes and AutoQuality can't be used together")
+	}
+	if maxBytes == 0 && autoQuality == nil {
+		if quality == 0 {
+			return nil, fmt.Errorf("either Quality, MaxBytes or AutoQuality should be set")
+		}
+		return nil, nil
+	}
+
+	s := &qualitySearch{maxBytes: maxBytes, minQuality: 10, maxQuality: 95}
+	if autoQuality != nil {
+		s.minScore = autoQuality.MinScore
+	}
+	if minQuality != 0 {
+		s.minQuality = minQuality
+	}
+	if maxQuality != 0 {
+		s.maxQuality = maxQuality
+	}
+	if s.minQuality > s.maxQuality {
+		return nil, fmt.Errorf("MinQuality %d is above MaxQuality %d", s.minQuality, s.maxQuality)
+	}
+	return s, nil
+}
+
+// encode encodes with the fixed quality when s is nil, and otherwise binary searches the quality, assuming both
+// the encoded size and the score grow with it. score decodes an encoding and compares it against the resized image,
+// and is only called for AutoQuality. If no quality meets the budget or the score, the closest one is used anyway,
+// so the image slot is never left empty. The chosen quality and score are returned as misc metadata for the output.
+func (s *qualitySearch) encode(name string, quality int, encode func(quality int) ([]byte, error), score func(data []byte) (float64, error)) ([]byte, map[string]string, error) {
+	if s == nil {
+		data, err := encode(quality)
+		return data, nil, err
+	}
+
+	var bestData []byte
+	var bestScore float64
+	bestQuality := 0
+	lo, hi := s.minQuality, s.maxQuality
+	for lo <= hi {
+		quality := (lo + hi) / 2
+		data, err := encode(quality)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// budgets look for the highest quality that fits, scores for the lowest quality that is good enough
+		if s.maxBytes != 0 {
+			if len(data) <= s.maxBytes {
+				bestData, bestQuality = data, quality
+				lo = quality + 1
+			} else {
+				hi = quality - 1
+			}
+			continue
+		}
+
+		ssim, err := score(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ssim >= s.minScore {
+			bestData, bestQuality, bestScore = data, quality, ssim
+			hi = quality - 1
+		} else {
+			lo = quality + 1
+		}
+	}
+
+	if bestData == nil {
+		fallback := s.minQuality
+		if s.maxBytes == 0 {
+			fallback = s.maxQuality
+		}
+		data, err := encode(fallback)
+		if err != nil {
+			return nil, nil, err
+		}
+		if s.maxBytes != 0 {
+			slog.Warn("output does not fit into byte budget even at the lowest quality",
+				slog.String("name", name), slog.Int("quality", fallback), slog.Int("bytes", len(data)), slog.Int("max_bytes", s.maxBytes))
+			return data, map[string]string{"quality": strconv.Itoa(fallback)}, nil
+		}
+		ssim, err := score(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		slog.Warn("output does not reach target score even at the highest quality",
+			slog.String("name", name), slog.Int("quality", fallback), slog.Float64("ssim", ssim), slog.Float64("min_score", s.minScore))
+		return data, scoreMisc(fallback, ssim), nil
+	}
+
+	if s.maxBytes != 0 {
+		slog.Info("chose quality to fit into byte budget",
+			slog.String("name", name), slog.Int("quality", bestQuality), slog.Int("bytes", len(bestData)), slog.Int("max_bytes", s.maxBytes))
+		return bestData, map[string]string{"quality": strconv.Itoa(bestQuality)}, nil
+	}
+	slog.Info("chose quality to reach target score",
+		slog.String("name", name), slog.Int("quality", bestQuality), slog.Float64("ssim", bestScore), slog.Float64("min_score", s.minScore))
+	return bestData, scoreMisc(bestQuality, bestScore), nil
+}
+
+func scoreMisc(quality int, ssim float64) map[string]string {
+	return map[string]string{"quality": strconv.Itoa(quality), "ssim": strconv.FormatFloat(ssim, 'f', 4, 64)}
+}
@@ -0,0 +1,126 @@
+package converter
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+)
+
+func TestNewQualitySearch(t *testing.T) {
+	for _, tc := range []struct {
+		name                                      string
+		quality, maxBytes, minQuality, maxQuality int
+		autoQuality                               *config.AutoQualityConfig
+		wantNil, wantErr                          bool
+	}{
+		{name: "fixed quality", quality: 80, wantNil: true},
+		{name: "no quality", wantErr: true},
+		{name: "max bytes", maxBytes: 1000},
+		{name: "auto quality", autoQuality: &config.AutoQualityConfig{MinScore: 0.9}},
+		{name: "max bytes and auto quality", maxBytes: 1000, autoQuality: &config.AutoQualityConfig{MinScore: 0.9}, wantErr: true},
+		{name: "min quality above max quality", maxBytes: 1000, minQuality: 60, maxQuality: 50, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := newQualitySearch(tc.quality, tc.maxBytes, tc.minQuality, tc.maxQuality, tc.autoQuality)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tc.wantErr)
+			}
+			if err == nil && (s == nil) != tc.wantNil {
+				t.Errorf("got search %v, want nil %t", s, tc.wantNil)
+			}
+		})
+	}
+}
+
+func TestQualitySearchEncode(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		search   *qualitySearch
+		wantSize int
+		wantMisc map[string]string
+	}{
+		{
+			name:     "fixed quality",
+			search:   nil,
+			wantSize: 800,
+		},
+		{
+			name:     "highest quality within budget",
+			search:   &qualitySearch{maxBytes: 555, minQuality: 10, maxQuality: 95},
+			wantSize: 550,
+			wantMisc: map[string]string{"quality": "55"},
+		},
+		{
+			name:     "budget above max quality",
+			search:   &qualitySearch{maxBytes: 5000, minQuality: 10, maxQuality: 95},
+			wantSize: 950,
+			wantMisc: map[string]string{"quality": "95"},
+		},
+		{
+			name:     "budget below min quality",
+			search:   &qualitySearch{maxBytes: 50, minQuality: 10, maxQuality: 95},
+			wantSize: 100,
+			wantMisc: map[string]string{"quality": "10"},
+		},
+		{
+			name:     "budget within custom range",
+			search:   &qualitySearch{maxBytes: 5000, minQuality: 30, maxQuality: 60},
+			wantSize: 600,
+			wantMisc: map[string]string{"quality": "60"},
+		},
+		{
+			name:     "lowest quality reaching score",
+			search:   &qualitySearch{minScore: 0.72, minQuality: 10, maxQuality: 95},
+			wantSize: 720,
+			wantMisc: map[string]string{"quality": "72", "ssim": "0.7200"},
+		},
+		{
+			name:     "score reached at min quality",
+			search:   &qualitySearch{minScore: 0.05, minQuality: 10, maxQuality: 95},
+			wantSize: 100,
+			wantMisc: map[string]string{"quality": "10", "ssim": "0.1000"},
+		},
+		{
+			name:     "score above max quality",
+			search:   &qualitySearch{minScore: 0.99, minQuality: 10, maxQuality: 95},
+			wantSize: 950,
+			wantMisc: map[string]string{"quality": "95", "ssim": "0.9500"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the stub encoding takes 10 bytes and scores 0.01 per quality step
+			encoded := []int{}
+			encode := func(quality int) ([]byte, error) {
+				encoded = append(encoded, quality)
+				return make([]byte, quality*10), nil
+			}
+			score := func(data []byte) (float64, error) {
+				return float64(len(data)) / 1000, nil
+			}
+
+			data, misc, err := tc.search.encode("test", 80, encode, score)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if len(data) != tc.wantSize {
+				t.Errorf("got %d bytes, want %d", len(data), tc.wantSize)
+			}
+			if !maps.Equal(misc, tc.wantMisc) {
+				t.Errorf("got misc %v, want %v", misc, tc.wantMisc)
+			}
+			if tc.search == nil {
+				return
+			}
+			for _, quality := range encoded {
+				if quality < tc.search.minQuality || quality > tc.search.maxQuality {
+					t.Errorf("encoded with quality %d outside of %d..%d", quality, tc.search.minQuality, tc.search.maxQuality)
+				}
+			}
+			// a binary search over at most 100 qualities, plus the fallback
+			if len(encoded) > 8 {
+				t.Errorf("encoded %d times, want at most 8", len(encoded))
+			}
+		})
+	}
+}
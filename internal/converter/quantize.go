@@ -0,0 +1,126 @@
+package converter
+
+import (
+	"image"
+	"image/color"
+	"slices"
+)
+
+// maxQuantizeSamples bounds the number of pixels fed into the quantizer, so big images are sampled on a grid instead.
+const maxQuantizeSamples = 1 << 18
+
+// colorBox is a set of pixels that median cut keeps splitting until there are enough boxes for the palette.
+type colorBox struct {
+	pixels []color.NRGBA
+}
+
+// channel returns the value of the n-th NRGBA channel of c.
+func channel(c color.NRGBA, n int) uint8 {
+	switch n {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	case 2:
+		return c.B
+	default:
+		return c.A
+	}
+}
+
+// widestChannel returns the channel with the largest value range within the box, and that range.
+func (b *colorBox) widestChannel() (int, int) {
+	bestChannel, bestRange := 0, -1
+	for n := range 4 {
+		lo, hi := uint8(255), uint8(0)
+		for _, px := range b.pixels {
+			v := channel(px, n)
+			lo = min(lo, v)
+			hi = max(hi, v)
+		}
+		if int(hi)-int(lo) > bestRange {
+			bestChannel, bestRange = n, int(hi)-int(lo)
+		}
+	}
+	return bestChannel, bestRange
+}
+
+// average returns the mean color of the box.
+func (b *colorBox) average() color.NRGBA {
+	var r, g, bl, a int
+	for _, px := range b.pixels {
+		r += int(px.R)
+		g += int(px.G)
+		bl += int(px.B)
+		a += int(px.A)
+	}
+	n := len(b.pixels)
+	return color.NRGBA{uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((bl + n/2) / n), uint8((a + n/2) / n)}
+}
+
+// samplePixels collects up to maxQuantizeSamples pixels of img, taken on an even grid.
+func samplePixels(img image.Image) []color.NRGBA {
+	b := img.Bounds()
+	step := 1
+	for b.Dx()*b.Dy()/(step*step) > maxQuantizeSamples {
+		step++
+	}
+
+	pixels := make([]color.NRGBA, 0, (b.Dx()/step+1)*(b.Dy()/step+1))
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			pixels = append(pixels, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+		}
+	}
+	return pixels
+}
+
+// medianCut splits the pixels of img into at most maxColors boxes, always cutting the box
+// with the widest channel range at its median. Boxes are returned sorted by pixel count, largest first.
+func medianCut(img image.Image, maxColors int) []colorBox {
+	pixels := samplePixels(img)
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	boxes := []colorBox{{pixels}}
+	for len(boxes) < maxColors {
+		splitIndex, splitChannel, splitRange := -1, 0, 0
+		for i := range boxes {
+			if len(boxes[i].pixels) < 2 {
+				continue
+			}
+			n, r := boxes[i].widestChannel()
+			// weight the range by the population, so big uniform areas still get their shades
+			if r*len(boxes[i].pixels) > splitRange {
+				splitIndex, splitChannel, splitRange = i, n, r*len(boxes[i].pixels)
+			}
+		}
+		if splitIndex < 0 {
+			break
+		}
+
+		box := boxes[splitIndex].pixels
+		slices.SortFunc(box, func(a, b color.NRGBA) int {
+			return int(channel(a, splitChannel)) - int(channel(b, splitChannel))
+		})
+		median := len(box) / 2
+		boxes[splitIndex] = colorBox{box[:median]}
+		boxes = append(boxes, colorBox{box[median:]})
+	}
+
+	slices.SortStableFunc(boxes, func(a, b colorBox) int {
+		return len(b.pixels) - len(a.pixels)
+	})
+	return boxes
+}
+
+// medianCutPalette builds a palette of at most maxColors colors representing img.
+func medianCutPalette(img image.Image, maxColors int) color.Palette {
+	boxes := medianCut(img, maxColors)
+	palette := make(color.Palette, 0, len(boxes))
+	for i := range boxes {
+		palette = append(palette, boxes[i].average())
+	}
+	return palette
+}
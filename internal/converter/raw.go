@@ -0,0 +1,153 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"slices"
+	"strings"
+)
+
+const (
+	photometricCFA       = 32803
+	photometricLinearRaw = 34892
+)
+
+// sniffRaw tells whether data is a TIFF-based camera RAW file, and which one, judging by its structure:
+// the Canon CR2 signature, the DNGVersion tag, or sensor (CFA or linear raw) image data in any of its IFDs.
+func sniffRaw(data []byte) (string, bool) {
+	t, err := parseTiff(data)
+	if err != nil {
+		return "", false
+	}
+	if len(data) >= 10 && string(data[8:10]) == "CR" {
+		return "image/x-canon-cr2", true
+	}
+
+	entries, _, err := t.readIFD(t.firstIFD())
+	if err != nil {
+		return "", false
+	}
+	if _, ok := findTiffEntry(entries, tiffTagDNGVersion); ok {
+		return "image/x-adobe-dng", true
+	}
+
+	hasSensorData := false
+	walkTiffImages(t, func(entries []tiffEntry) {
+		if photometric, ok := findTiffEntry(entries, tiffTagPhotometric); ok {
+			if v, ok := photometric.uint(t, 0); ok && (v == photometricCFA || v == photometricLinearRaw) {
+				hasSensorData = true
+			}
+		}
+	})
+	if !hasSensorData {
+		return "", false
+	}
+
+	cameraMake := ""
+	if entry, ok := findTiffEntry(entries, tiffTagMake); ok {
+		cameraMake = strings.ToUpper(strings.TrimRight(string(entry.value), "\x00 "))
+	}
+	switch {
+	case strings.HasPrefix(cameraMake, "NIKON"):
+		return "image/x-nikon-nef", true
+	case strings.HasPrefix(cameraMake, "SONY"):
+		return "image/x-sony-arw", true
+	case strings.HasPrefix(cameraMake, "CANON"):
+		return "image/x-canon-cr2", true
+	default:
+		return "image/x-dcraw", true
+	}
+}
+
+// rawPreviews returns the embedded JPEG previews of a RAW file, from the largest to the smallest.
+// Sensor data is skipped even when it is JPEG-compressed, as it is lossless JPEG.
+func rawPreviews(data []byte) ([][]byte, error) {
+	t, err := parseTiff(data)
+	if err != nil {
+		return nil, err
+	}
+
+	previews := [][]byte{}
+	addPreview := func(offset, length uint32) {
+		if length == 0 || uint64(offset)+uint64(length) > uint64(len(data)) {
+			return
+		}
+		preview := data[offset : offset+length]
+		if bytes.HasPrefix(preview, []byte{0xFF, 0xD8}) {
+			previews = append(previews, preview)
+		}
+	}
+
+	walkTiffImages(t, func(entries []tiffEntry) {
+		if photometric, ok := findTiffEntry(entries, tiffTagPhotometric); ok {
+			if v, ok := photometric.uint(t, 0); ok && (v == photometricCFA || v == photometricLinearRaw) {
+				return
+			}
+		}
+
+		offsetEntry, okOffset := findTiffEntry(entries, tiffTagJPEGInterchange)
+		lengthEntry, okLength := findTiffEntry(entries, tiffTagJPEGInterchangeBytes)
+		if okOffset && okLength {
+			offset, _ := offsetEntry.uint(t, 0)
+			length, _ := lengthEntry.uint(t, 0)
+			addPreview(offset, length)
+		}
+
+		compression, ok := findTiffEntry(entries, tiffTagCompression)
+		if !ok {
+			return
+		}
+		if v, _ := compression.uint(t, 0); v != 6 && v != 7 {
+			return
+		}
+		offsetEntry, okOffset = findTiffEntry(entries, tiffTagStripOffsets)
+		lengthEntry, okLength = findTiffEntry(entries, tiffTagStripByteCounts)
+		if okOffset && okLength && offsetEntry.count == 1 && lengthEntry.count == 1 {
+			offset, _ := offsetEntry.uint(t, 0)
+			length, _ := lengthEntry.uint(t, 0)
+			addPreview(offset, length)
+		}
+	})
+
+	slices.SortFunc(previews, func(a, b []byte) int {
+		return len(b) - len(a)
+	})
+	return previews, nil
+}
+
+// rawPreview picks the largest embedded JPEG preview of a RAW file with a header the standard JPEG decoder supports.
+// Both the limits and decoding use this preview, so it isn't swapped for another one when its body turns out corrupt.
+func rawPreview(data []byte) ([]byte, image.Config, error) {
+	previews, err := rawPreviews(data)
+	if err != nil {
+		return nil, image.Config{}, err
+	}
+
+	var lastErr error
+	for _, preview := range previews {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(preview))
+		if err == nil {
+			return preview, cfg, nil
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		return nil, image.Config{}, fmt.Errorf("no decodable preview found: %w", lastErr)
+	}
+	return nil, image.Config{}, fmt.Errorf("no embedded jpeg preview found")
+}
+
+func decodeRawPreview(data []byte) (image.Image, error) {
+	preview, _, err := rawPreview(data)
+	if err != nil {
+		return nil, err
+	}
+	return jpeg.Decode(bytes.NewReader(preview))
+}
+
+func decodeRawPreviewConfig(data []byte) (image.Config, error) {
+	_, cfg, err := rawPreview(data)
+	return cfg, err
+}
@@ -0,0 +1,237 @@
+package converter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+)
+
+type sizeOptions struct {
+	// mode is one of fit, fill, pad and smart
+	mode      string
+	maxWidth  int
+	maxHeight int
+	// background fills the borders added in pad mode
+	background color.Color
+	resampler  draw.Interpolator
+	// linearLight resamples in linear light instead of on sRGB-encoded values
+	linearLight bool
+	sharpen     *config.SharpenConfig
+}
+
+// lanczos3 is the Lanczos kernel with a support of 3, sharper than CatmullRom when downscaling photos.
+var lanczos3 = &draw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	return 3 * math.Sin(math.Pi*t) * math.Sin(math.Pi*t/3) / (math.Pi * math.Pi * t * t)
+}}
+
+var resamplers = map[string]draw.Interpolator{
+	"NearestNeighbor": draw.NearestNeighbor,
+	"ApproxBiLinear":  draw.ApproxBiLinear,
+	"BiLinear":        draw.BiLinear,
+	"CatmullRom":      draw.CatmullRom,
+	"Lanczos3":        lanczos3,
+}
+
+func newSizeOptions(cfg config.SizeConfig) (sizeOptions, error) {
+	opts := sizeOptions{mode: cfg.Mode, maxWidth: cfg.MaxWidth, maxHeight: cfg.MaxHeight, background: color.Transparent, resampler: draw.CatmullRom, linearLight: cfg.LinearLight, sharpen: cfg.Sharpen}
+
+	switch cfg.Mode {
+	case "":
+		opts.mode = "fit"
+	case "fit":
+	case "fill", "pad", "smart":
+		if cfg.MaxWidth <= 0 || cfg.MaxHeight <= 0 {
+			return sizeOptions{}, fmt.Errorf("size mode %s requires both MaxWidth and MaxHeight", cfg.Mode)
+		}
+	default:
+		return sizeOptions{}, fmt.Errorf("unsupported size mode: %s", cfg.Mode)
+	}
+
+	if cfg.Resampler != "" {
+		resampler, ok := resamplers[cfg.Resampler]
+		if !ok {
+			return sizeOptions{}, fmt.Errorf("unsupported resampler: %s", cfg.Resampler)
+		}
+		opts.resampler = resampler
+	}
+
+	if cfg.Sharpen != nil && (cfg.Sharpen.Radius <= 0 || cfg.Sharpen.Amount <= 0 || cfg.Sharpen.Threshold < 0 || cfg.Sharpen.Threshold > 255) {
+		return sizeOptions{}, fmt.Errorf("sharpening needs a positive radius and amount, and a threshold between 0 and 255")
+	}
+
+	if cfg.Background != "" {
+		background, err := parseHexColor(cfg.Background)
+		if err != nil {
+			return sizeOptions{}, err
+		}
+		opts.background = background
+	}
+
+	return opts, nil
+}
+
+// parseHexColor parses #RGB, #RRGGBB and #RRGGBBAA colors.
+func parseHexColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return nil, fmt.Errorf("invalid color: %s", s)
+	}
+	return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
+// resize brings src to the configured size. fit scales it down to fit inside the box, pad does the same and
+// centers the result on a canvas of exactly the box size, while fill and smart scale it to cover the box
+// and crop the overflow, either around the center or around the most detailed area. A focal point, when known,
+// takes precedence over both. The result is sharpened afterwards, if configured.
+func (o sizeOptions) resize(src image.Image, focus *input.FocalPoint) image.Image {
+	switch o.mode {
+	case "pad":
+		fitted := o.applySharpen(o.resizeToFit(src))
+		canvas := image.NewRGBA(image.Rect(0, 0, o.maxWidth, o.maxHeight))
+		draw.Draw(canvas, canvas.Rect, image.NewUniform(o.background), image.Point{}, draw.Src)
+		b := fitted.Bounds()
+		offset := image.Pt((o.maxWidth-b.Dx())/2, (o.maxHeight-b.Dy())/2)
+		draw.Draw(canvas, b.Sub(b.Min).Add(offset), fitted, b.Min, draw.Over)
+		return canvas
+	case "fill", "smart":
+		crop := coverCrop(src, o.maxWidth, o.maxHeight, o.mode == "smart", focus)
+		slog.Debug("calculated crop", slog.String("mode", o.mode), slog.String("crop", crop.String()))
+		return o.applySharpen(o.scale(src, crop, o.maxWidth, o.maxHeight))
+	default:
+		return o.applySharpen(o.resizeToFit(src))
+	}
+}
+
+func (o sizeOptions) applySharpen(img image.Image) image.Image {
+	if o.sharpen == nil {
+		return img
+	}
+	return unsharpMask(img, o.sharpen.Radius, o.sharpen.Amount, o.sharpen.Threshold)
+}
+
+// coverCrop returns the largest window of src with the aspect ratio of width x height. The window is centered
+// on the focal point if there is one, or else on the image, unless smart is set, in which case it's placed
+// over the area with the most detail.
+func coverCrop(src image.Image, width, height int, smart bool, focus *input.FocalPoint) image.Rectangle {
+	bounds := src.Bounds()
+	cropWidth, cropHeight := bounds.Dx(), bounds.Dy()
+	if bounds.Dx()*height > bounds.Dy()*width {
+		cropWidth = max(1, (bounds.Dy()*width+height/2)/height)
+	} else {
+		cropHeight = max(1, (bounds.Dx()*height+width/2)/width)
+	}
+
+	offset := image.Pt((bounds.Dx()-cropWidth)/2, (bounds.Dy()-cropHeight)/2)
+	switch {
+	case focus != nil:
+		offset.X = min(max(int(focus.X*float64(bounds.Dx())+0.5)-cropWidth/2, 0), bounds.Dx()-cropWidth)
+		offset.Y = min(max(int(focus.Y*float64(bounds.Dy())+0.5)-cropHeight/2, 0), bounds.Dy()-cropHeight)
+	case smart && (cropWidth != bounds.Dx() || cropHeight != bounds.Dy()):
+		offset = smartCropOffset(src, cropWidth, cropHeight)
+	}
+
+	return image.Rect(0, 0, cropWidth, cropHeight).Add(bounds.Min).Add(offset)
+}
+
+// animationFocus returns the focal point to crop every frame of an animation around, so smart crops
+// don't jump from frame to frame: the center of the smart crop of the first frame is used for all of them.
+func (o sizeOptions) animationFocus(first image.Image, focus *input.FocalPoint) *input.FocalPoint {
+	if focus != nil || o.mode != "smart" {
+		return focus
+	}
+	b := first.Bounds()
+	crop := coverCrop(first, o.maxWidth, o.maxHeight, true, nil)
+	return &input.FocalPoint{
+		X: float64(crop.Min.X-b.Min.X+crop.Dx()/2) / float64(b.Dx()),
+		Y: float64(crop.Min.Y-b.Min.Y+crop.Dy()/2) / float64(b.Dy()),
+	}
+}
+
+// resizeToFit scales src down so it fits inside maxWidth x maxHeight, keeping the aspect ratio.
+// Zero dimensions are unbounded, and images which already fit are returned as is.
+func (o sizeOptions) resizeToFit(src image.Image) image.Image {
+	width, height, ok := o.fitSize(src.Bounds())
+	if !ok {
+		return src
+	}
+	return o.scale(src, src.Bounds(), width, height)
+}
+
+// fitSize returns the size an image of the given bounds is scaled down to by resizeToFit, or false if it already fits.
+func (o sizeOptions) fitSize(bounds image.Rectangle) (int, int, bool) {
+	xCoef := 1.0
+	if o.maxWidth > 0 {
+		xCoef = float64(o.maxWidth) / float64(bounds.Dx())
+	}
+	yCoef := 1.0
+	if o.maxHeight > 0 {
+		yCoef = float64(o.maxHeight) / float64(bounds.Dy())
+	}
+	slog.Debug("calculated coefficients", slog.Float64("x_coef", xCoef), slog.Float64("y_coef", yCoef))
+
+	minCoef := xCoef
+	if yCoef < minCoef {
+		minCoef = yCoef
+	}
+
+	if minCoef >= 1.0 {
+		return 0, 0, false
+	}
+
+	return int(float64(bounds.Dx())*minCoef + 0.5), int(float64(bounds.Dy())*minCoef + 0.5), true
+}
+
+// resizeShared resizes a decoded image shared by the converters of an input. Fits are kept along with it, so that
+// smaller fits are scaled from the smallest of them that is still large enough, instead of from the full resolution.
+// The size of the result is the same as when scaling from the full resolution.
+func (o sizeOptions) resizeShared(d *decodedImage, focus *input.FocalPoint) image.Image {
+	if o.mode != "fit" {
+		return o.resize(d.img, focus)
+	}
+
+	width, height, ok := o.fitSize(d.img.Bounds())
+	if !ok {
+		return o.applySharpen(d.img)
+	}
+
+	key := cascadeKey{o.resampler, o.linearLight}
+	base := d.cascadeBase(key, width, height)
+	slog.Debug("scaling from cascade", slog.String("base", base.Bounds().String()), slog.Int("width", width), slog.Int("height", height))
+	fitted := o.scale(base, base.Bounds(), width, height)
+	d.addToCascade(key, fitted)
+	return o.applySharpen(fitted)
+}
+
+// scale resamples the sr part of src to width x height. In linear light mode the pixels are decoded from sRGB
+// into a 16-bit buffer first, so bright and dark details are averaged by their light rather than their encoding.
+func (o sizeOptions) scale(src image.Image, sr image.Rectangle, width, height int) image.Image {
+	if !o.linearLight {
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		o.resampler.Scale(dst, dst.Rect, src, sr, draw.Over, nil)
+		return dst
+	}
+
+	linear := toLinearLight(src, sr)
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+	o.resampler.Scale(dst, dst.Rect, linear, linear.Rect, draw.Over, nil)
+	return fromLinearLight(dst)
+}
@@ -0,0 +1,34 @@
+package converter
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// BenchmarkScale compares the cost of downscaling a photo-sized image on sRGB-encoded values and in linear light.
+func BenchmarkScale(b *testing.B) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3000, 2000))
+	for y := range src.Rect.Dy() {
+		for x := range src.Rect.Dx() {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+
+	for _, bc := range []struct {
+		name        string
+		linearLight bool
+	}{
+		{"sRGB", false},
+		{"LinearLight", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			opts := sizeOptions{resampler: draw.CatmullRom, linearLight: bc.linearLight}
+			for b.Loop() {
+				opts.scale(src, src.Rect, 480, 320)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package converter
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// unsharpMask sharpens img by adding amount times the difference between it and its gaussian blur with the given
+// radius (sigma). Differences below threshold (0-255) are left alone, so flat areas and noise aren't amplified.
+func unsharpMask(img image.Image, radius, amount float64, threshold int) image.Image {
+	if radius <= 0 || amount <= 0 {
+		return img
+	}
+
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+
+	blurred := gaussianBlur(src, radius)
+	dst := image.NewRGBA(src.Rect)
+	for i := 0; i+4 <= len(src.Pix); i += 4 {
+		alpha := float64(src.Pix[i+3])
+		for c := range 3 {
+			diff := float64(src.Pix[i+c]) - blurred[i+c]
+			v := float64(src.Pix[i+c])
+			if math.Abs(diff) >= float64(threshold) {
+				v += amount * diff
+			}
+			// premultiplied color can't exceed alpha
+			dst.Pix[i+c] = uint8(math.Round(min(max(v, 0), alpha)))
+		}
+		dst.Pix[i+3] = src.Pix[i+3]
+	}
+
+	return dst
+}
+
+// gaussianBlur returns the blurred color channels of img, in the layout of its Pix, using a separable kernel.
+func gaussianBlur(img *image.RGBA, sigma float64) []float64 {
+	size := int(math.Ceil(sigma * 3))
+	kernel := make([]float64, 2*size+1)
+	sum := 0.0
+	for i := range kernel {
+		x := float64(i - size)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	horizontal := make([]float64, len(img.Pix))
+	for y := range h {
+		for x := range w {
+			for c := range 3 {
+				v := 0.0
+				for k, weight := range kernel {
+					sx := min(max(x+k-size, 0), w-1)
+					v += weight * float64(img.Pix[y*img.Stride+sx*4+c])
+				}
+				horizontal[y*img.Stride+x*4+c] = v
+			}
+		}
+	}
+
+	blurred := make([]float64, len(img.Pix))
+	for y := range h {
+		for x := range w {
+			for c := range 3 {
+				v := 0.0
+				for k, weight := range kernel {
+					sy := min(max(y+k-size, 0), h-1)
+					v += weight * horizontal[sy*img.Stride+x*4+c]
+				}
+				blurred[y*img.Stride+x*4+c] = v
+			}
+		}
+	}
+
+	return blurred
+}
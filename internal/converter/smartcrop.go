@@ -0,0 +1,126 @@
+package converter
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// smartCropAnalysisSize is the longest side of the copy of the image the crop is chosen on
+	smartCropAnalysisSize  = 256
+	smartCropHistogramBins = 16
+	// smartCropEntropyWeight and smartCropCenterWeight balance entropy and closeness to the center against edge energy
+	smartCropEntropyWeight = 0.5
+	smartCropCenterWeight  = 0.1
+)
+
+// smartCropOffset picks the position of a cropWidth x cropHeight window inside src with the most detail, judged by
+// the edge energy and the entropy of luminance inside the window. Only one axis has slack, as the window
+// covers src along the other one.
+func smartCropOffset(src image.Image, cropWidth, cropHeight int) image.Point {
+	b := src.Bounds()
+	scale := min(1, float64(smartCropAnalysisSize)/float64(max(b.Dx(), b.Dy())))
+	gray := image.NewGray(image.Rect(0, 0, max(1, int(float64(b.Dx())*scale+0.5)), max(1, int(float64(b.Dy())*scale+0.5))))
+	draw.ApproxBiLinear.Scale(gray, gray.Rect, src, b, draw.Src, nil)
+
+	horizontal := cropWidth < b.Dx()
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	lines, lineLength := h, w
+	if horizontal {
+		lines, lineLength = w, h
+	}
+	at := func(line, i int) int {
+		if horizontal {
+			return int(gray.Pix[i*gray.Stride+line])
+		}
+		return int(gray.Pix[line*gray.Stride+i])
+	}
+
+	// energy and luminance histograms are collected per line across the slack axis, so windows can slide over them
+	energy := make([]float64, lines)
+	histograms := make([][smartCropHistogramBins]int, lines)
+	for line := range lines {
+		for i := range lineLength {
+			v := at(line, i)
+			histograms[line][v*smartCropHistogramBins/256]++
+			if line+1 < lines {
+				energy[line] += math.Abs(float64(at(line+1, i) - v))
+			}
+			if i+1 < lineLength {
+				energy[line] += math.Abs(float64(at(line, i+1) - v))
+			}
+		}
+	}
+
+	totalEnergy := 0.0
+	for _, e := range energy {
+		totalEnergy += e
+	}
+
+	window := lines
+	if horizontal {
+		window = min(lines, max(1, int(float64(cropWidth)*float64(w)/float64(b.Dx())+0.5)))
+	} else {
+		window = min(lines, max(1, int(float64(cropHeight)*float64(h)/float64(b.Dy())+0.5)))
+	}
+
+	var histogram [smartCropHistogramBins]int
+	windowEnergy := 0.0
+	for line := range window {
+		windowEnergy += energy[line]
+		for bin, n := range histograms[line] {
+			histogram[bin] += n
+		}
+	}
+
+	best, bestScore := 0, math.Inf(-1)
+	maxOffset := lines - window
+	for offset := 0; offset <= maxOffset; offset++ {
+		if offset > 0 {
+			windowEnergy += energy[offset+window-1] - energy[offset-1]
+			for bin := range histogram {
+				histogram[bin] += histograms[offset+window-1][bin] - histograms[offset-1][bin]
+			}
+		}
+
+		score := smartCropEntropyWeight * histogramEntropy(histogram[:])
+		if totalEnergy > 0 {
+			score += windowEnergy / totalEnergy
+		}
+		if maxOffset > 0 {
+			score -= smartCropCenterWeight * math.Abs(float64(2*offset-maxOffset)) / float64(maxOffset)
+		}
+		if score > bestScore {
+			best, bestScore = offset, score
+		}
+	}
+
+	if horizontal {
+		x := int(float64(best)*float64(b.Dx())/float64(w) + 0.5)
+		return image.Pt(min(x, b.Dx()-cropWidth), 0)
+	}
+	y := int(float64(best)*float64(b.Dy())/float64(h) + 0.5)
+	return image.Pt(0, min(y, b.Dy()-cropHeight))
+}
+
+// histogramEntropy returns the Shannon entropy of a histogram, normalized to 0..1.
+func histogramEntropy(histogram []int) float64 {
+	total := 0
+	for _, n := range histogram {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	entropy := 0.0
+	for _, n := range histogram {
+		if n > 0 {
+			p := float64(n) / float64(total)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy / math.Log2(float64(len(histogram)))
+}
@@ -0,0 +1,120 @@
+package converter
+
+import (
+	"bytes"
+	"cmp"
+	"image"
+	"math"
+	"slices"
+
+	"golang.org/x/image/draw"
+
+	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
+	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
+)
+
+// Source is an input read once and shared by all converters of the file. Still images are decoded on first use
+// and kept for converters with the same decode options, along with the downscales made from them.
+// A Source is not safe for concurrent use.
+type Source struct {
+	Metadata *input.MetadataStruct
+	data     []byte
+	limits   config.InputLimitsConfig
+	decoded  map[decodeOptions]*decodedImage
+}
+
+func NewSource(inputMetadata *input.MetadataStruct, data []byte, limits config.InputLimitsConfig) *Source {
+	return &Source{Metadata: inputMetadata, data: data, limits: limits, decoded: make(map[decodeOptions]*decodedImage)}
+}
+
+// decodedImage is a decoded still image, which converters must not modify.
+type decodedImage struct {
+	img  image.Image
+	meta *imageMetadata
+	// cascade holds downscales of img made by fits with the same resampling, from the largest to the smallest
+	cascade map[cascadeKey][]image.Image
+}
+
+type cascadeKey struct {
+	resampler   draw.Interpolator
+	linearLight bool
+}
+
+func (s *Source) decode(opts decodeOptions) (*decodedImage, error) {
+	opts.limits = s.limits
+	if d, ok := s.decoded[opts]; ok {
+		return d, nil
+	}
+	img, meta, err := decodeImage(s.Metadata, bytes.NewReader(s.data), opts)
+	if err != nil {
+		return nil, err
+	}
+	d := &decodedImage{img: img, meta: meta, cascade: make(map[cascadeKey][]image.Image)}
+	s.decoded[opts] = d
+	return d, nil
+}
+
+// decodeAnimation decodes all frames every time, as converters map them in place.
+func (s *Source) decodeAnimation(opts decodeOptions) (*animation, *imageMetadata, error) {
+	opts.limits = s.limits
+	return decodeAnimation(s.Metadata, bytes.NewReader(s.data), opts)
+}
+
+// cascadeBase returns the smallest image to scale from to get a width x height fit: either img, or a downscale
+// of it which is still at least that large.
+func (d *decodedImage) cascadeBase(key cascadeKey, width, height int) image.Image {
+	scaled := d.cascade[key]
+	for i := len(scaled) - 1; i >= 0; i-- {
+		if b := scaled[i].Bounds(); b.Dx() >= width && b.Dy() >= height {
+			return scaled[i]
+		}
+	}
+	return d.img
+}
+
+func (d *decodedImage) addToCascade(key cascadeKey, img image.Image) {
+	scaled := append(d.cascade[key], img)
+	slices.SortStableFunc(scaled, func(a, b image.Image) int {
+		return b.Bounds().Dx() - a.Bounds().Dx()
+	})
+	d.cascade[key] = scaled
+}
+
+// SizeOrder returns the indexes of converters ordered from the largest output to the smallest, so smaller outputs
+// can be scaled from larger ones. Unbounded sizes come first, converters without a size last, and ties keep the
+// configured order.
+func SizeOrder(cfgs []config.ConverterConfig) []int {
+	sizes := make([]int, len(cfgs))
+	for i, cfg := range cfgs {
+		var size *config.SizeConfig
+		switch c := cfg.Config.(type) {
+		case *config.WebpConfig:
+			size = &c.Size
+		case *config.JpegConfig:
+			size = &c.Size
+		case *config.AvifConfig:
+			size = &c.Size
+		case *config.PngConfig:
+			size = &c.Size
+		case *config.LqipConfig:
+			size = &c.Size
+		}
+		switch {
+		case size == nil:
+			sizes[i] = -1
+		case size.MaxWidth == 0 && size.MaxHeight == 0:
+			sizes[i] = math.MaxInt
+		default:
+			sizes[i] = max(size.MaxWidth, size.MaxHeight)
+		}
+	}
+
+	order := make([]int, len(cfgs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(sizes[b], sizes[a])
+	})
+	return order
+}
@@ -0,0 +1,64 @@
+package converter
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	// ssimWindow is the size of the square windows SSIM is averaged over, moved by half of it at a time
+	ssimWindow = 8
+	ssimC1     = (0.01 * 255) * (0.01 * 255)
+	ssimC2     = (0.03 * 255) * (0.03 * 255)
+)
+
+// luma returns the BT.601 luma of every pixel of img, which SSIM is computed on.
+func luma(img image.Image) []float64 {
+	b := img.Bounds()
+	values := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			values = append(values, 0.299*float64(c.R)+0.587*float64(c.G)+0.114*float64(c.B))
+		}
+	}
+	return values
+}
+
+// ssim computes the mean structural similarity of the luma of two images of the same size, 1 meaning identical.
+func ssim(reference, distorted image.Image) float64 {
+	w, h := reference.Bounds().Dx(), reference.Bounds().Dy()
+	if distorted.Bounds().Dx() != w || distorted.Bounds().Dy() != h {
+		return 0
+	}
+	a, b := luma(reference), luma(distorted)
+
+	windowW, windowH := min(ssimWindow, w), min(ssimWindow, h)
+	stepX, stepY := max(1, windowW/2), max(1, windowH/2)
+	total, windows := 0.0, 0
+	for y0 := 0; y0+windowH <= h; y0 += stepY {
+		for x0 := 0; x0+windowW <= w; x0 += stepX {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := y0; y < y0+windowH; y++ {
+				for x := x0; x < x0+windowW; x++ {
+					va, vb := a[y*w+x], b[y*w+x]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+			n := float64(windowW * windowH)
+			meanA, meanB := sumA/n, sumB/n
+			varA, varB := sumAA/n-meanA*meanA, sumBB/n-meanB*meanB
+			covariance := sumAB/n - meanA*meanB
+			total += (2*meanA*meanB + ssimC1) * (2*covariance + ssimC2) / ((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 0
+	}
+	return total / float64(windows)
+}
@@ -0,0 +1,222 @@
+package converter
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// tiffFile is a minimal reader of the TIFF structure, shared by every format built on it.
+type tiffFile struct {
+	data  []byte
+	order tiffByteOrder
+}
+
+type tiffByteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	// value holds the raw bytes of the value, whether it is stored inline or at an offset
+	value []byte
+}
+
+const (
+	tiffTagCompression          = 0x0103
+	tiffTagPhotometric          = 0x0106
+	tiffTagMake                 = 0x010F
+	tiffTagStripOffsets         = 0x0111
+	tiffTagStripByteCounts      = 0x0117
+	tiffTagSubIFDs              = 0x014A
+	tiffTagJPEGInterchange      = 0x0201
+	tiffTagJPEGInterchangeBytes = 0x0202
+	tiffTagDNGVersion           = 0xC612
+)
+
+var tiffTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
+
+func parseTiff(data []byte) (*tiffFile, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("tiff header is truncated")
+	}
+	switch string(data[0:4]) {
+	case "II*\x00":
+		return &tiffFile{data, binary.LittleEndian}, nil
+	case "MM\x00*":
+		return &tiffFile{data, binary.BigEndian}, nil
+	default:
+		return nil, fmt.Errorf("not a tiff structure")
+	}
+}
+
+func (t *tiffFile) firstIFD() uint32 {
+	return t.order.Uint32(t.data[4:8])
+}
+
+// readIFD returns the entries of the IFD at offset and the offset of the next one (0 for the last IFD).
+// Entries with values pointing outside of the data are skipped.
+func (t *tiffFile) readIFD(offset uint32) ([]tiffEntry, uint32, error) {
+	if offset < 8 || uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, 0, fmt.Errorf("ifd offset %d is out of bounds", offset)
+	}
+	count := uint32(t.order.Uint16(t.data[offset:]))
+	end := uint64(offset) + 2 + uint64(count)*12
+	if end+4 > uint64(len(t.data)) {
+		return nil, 0, fmt.Errorf("ifd at %d is truncated", offset)
+	}
+
+	entries := make([]tiffEntry, 0, count)
+	for i := range count {
+		raw := t.data[offset+2+i*12 : offset+2+(i+1)*12]
+		entry := tiffEntry{
+			tag:   t.order.Uint16(raw[0:2]),
+			typ:   t.order.Uint16(raw[2:4]),
+			count: t.order.Uint32(raw[4:8]),
+		}
+		size := uint64(tiffTypeSizes[entry.typ]) * uint64(entry.count)
+		if size <= 4 {
+			entry.value = raw[8 : 8+size]
+		} else {
+			valueOffset := uint64(t.order.Uint32(raw[8:12]))
+			if valueOffset+size > uint64(len(t.data)) {
+				continue
+			}
+			entry.value = t.data[valueOffset : valueOffset+size]
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, t.order.Uint32(t.data[end:]), nil
+}
+
+// uint returns the i-th value of a SHORT, LONG or IFD entry.
+func (e tiffEntry) uint(t *tiffFile, i int) (uint32, bool) {
+	switch e.typ {
+	case 3:
+		if len(e.value) < (i+1)*2 {
+			return 0, false
+		}
+		return uint32(t.order.Uint16(e.value[i*2:])), true
+	case 4, 13:
+		if len(e.value) < (i+1)*4 {
+			return 0, false
+		}
+		return t.order.Uint32(e.value[i*4:]), true
+	default:
+		return 0, false
+	}
+}
+
+func findTiffEntry(entries []tiffEntry, tag uint16) (tiffEntry, bool) {
+	for _, entry := range entries {
+		if entry.tag == tag {
+			return entry, true
+		}
+	}
+	return tiffEntry{}, false
+}
+
+// walkTiffImages calls fn for every IFD in the main chain and for their SubIFDs, guarding against loops.
+func walkTiffImages(t *tiffFile, fn func(entries []tiffEntry)) {
+	seen := map[uint32]struct{}{}
+	queue := []uint32{t.firstIFD()}
+	for len(queue) > 0 && len(seen) < 64 {
+		offset := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[offset]; ok || offset == 0 {
+			continue
+		}
+		seen[offset] = struct{}{}
+
+		entries, next, err := t.readIFD(offset)
+		if err != nil {
+			continue
+		}
+		fn(entries)
+
+		if subIFDs, ok := findTiffEntry(entries, tiffTagSubIFDs); ok {
+			for i := range int(subIFDs.count) {
+				if subOffset, ok := subIFDs.uint(t, i); ok {
+					queue = append(queue, subOffset)
+				}
+			}
+		}
+		queue = append(queue, next)
+	}
+}
+
+// tiffPage returns the data with its header pointing at the IFD of the given page, so a regular
+// decoder reads that page instead of the first one. Pages past the end fall back to the last one.
+func tiffPage(data []byte, page int) ([]byte, error) {
+	t, err := parseTiff(data)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := t.firstIFD()
+	seen := map[uint32]struct{}{}
+	for range page {
+		seen[offset] = struct{}{}
+		_, next, err := t.readIFD(offset)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[next]; next == 0 || ok {
+			break
+		}
+		offset = next
+	}
+
+	if offset == t.firstIFD() {
+		return data, nil
+	}
+
+	patched := make([]byte, len(data))
+	copy(patched, data)
+	t.order.PutUint32(patched[4:8], offset)
+	return patched, nil
+}
+
+// tiffIFDSize returns the size of an IFD with the given entries, including its out-of-line values.
+func tiffIFDSize(entries []tiffEntry) uint32 {
+	size := uint32(2 + 12*len(entries) + 4)
+	for _, entry := range entries {
+		if len(entry.value) > 4 {
+			size += uint32(len(entry.value)+1) &^ 1
+		}
+	}
+	return size
+}
+
+// appendTiffIFD appends an IFD to buf, which is expected to start at the TIFF header, placing out-of-line
+// values right after the directory. Entries have to be sorted by tag.
+func appendTiffIFD(buf []byte, order tiffByteOrder, entries []tiffEntry, next uint32) []byte {
+	dataOffset := uint32(len(buf) + 2 + 12*len(entries) + 4)
+	data := []byte{}
+
+	buf = order.AppendUint16(buf, uint16(len(entries)))
+	for _, entry := range entries {
+		buf = order.AppendUint16(buf, entry.tag)
+		buf = order.AppendUint16(buf, entry.typ)
+		buf = order.AppendUint32(buf, entry.count)
+		if len(entry.value) <= 4 {
+			inline := make([]byte, 4)
+			copy(inline, entry.value)
+			buf = append(buf, inline...)
+			continue
+		}
+		buf = order.AppendUint32(buf, dataOffset+uint32(len(data)))
+		data = append(data, entry.value...)
+		if len(entry.value)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+	buf = order.AppendUint32(buf, next)
+
+	return append(buf, data...)
+}
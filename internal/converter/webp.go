@@ -1,18 +1,12 @@
 package converter
 
 import (
+	"bytes"
 	"fmt"
 	"image"
-	"image/jpeg"
-	"image/png"
-	"io"
-	"log/slog"
 	"strings"
 
-	"golang.org/x/image/draw"
-
 	"github.com/SayaAndy/saya-today-thumbnail-generator/config"
-	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/input"
 	"github.com/SayaAndy/saya-today-thumbnail-generator/internal/client/output"
 	"github.com/kolesa-team/go-webp/encoder"
 	"github.com/kolesa-team/go-webp/webp"
@@ -21,10 +15,22 @@ import (
 var _ Converter = (*WebpConverter)(nil)
 
 type WebpConverter struct {
-	maxWidth     int
-	maxHeight    int
-	quality      int
-	outputClient output.OutputClient
+	size           sizeOptions
+	quality        int
+	lossless       bool
+	preset         encoder.EncodingPreset
+	method         *int
+	nearLossless   *int
+	alphaQuality   *int
+	sharpYuv       bool
+	filterStrength *int
+	targetSize     int
+	qualitySearch  *qualitySearch
+	keepAnimation  bool
+	decodeOptions  decodeOptions
+	overlay        *overlay
+	metadata       *metadataPolicy
+	outputClient   output.OutputClient
 }
 
 func NewWebpConverter(cfg *config.ConverterConfig) (Converter, error) {
@@ -33,71 +39,218 @@ func NewWebpConverter(cfg *config.ConverterConfig) (Converter, error) {
 	}
 	webpCfg := cfg.Config.(*config.WebpConfig)
 
+	qualitySearch, err := newQualitySearch(webpCfg.Quality, webpCfg.MaxBytes, webpCfg.MinQuality, webpCfg.MaxQuality, webpCfg.AutoQuality)
+	if err != nil {
+		return nil, err
+	}
+	if qualitySearch != nil && (webpCfg.TargetSize != 0 || (webpCfg.Mode != "" && webpCfg.Mode != "lossy")) {
+		return nil, fmt.Errorf("MaxBytes and AutoQuality are only supported in lossy mode without TargetSize")
+	}
+	if webpCfg.AutoQuality != nil && webpCfg.Animation.Mode == "keep" {
+		return nil, fmt.Errorf("AutoQuality is not supported when keeping animation")
+	}
+
+	metadata, err := newMetadataPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := newSizeOptions(webpCfg.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	decodeOptions, err := newDecodeOptions(cfg, webpCfg.Animation, webpCfg.IgnoreOrientation)
+	if err != nil {
+		return nil, err
+	}
+
+	overlay, err := newOverlay(cfg.Overlay)
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize overlay: %w", err)
+	}
+
 	outputClient, err := output.NewOutputClientMap[cfg.Output.Storage.Type](&cfg.Output)
 	if err != nil {
 		return nil, fmt.Errorf("fail to initialize output client: %w", err)
 	}
 
-	return &WebpConverter{webpCfg.Size.MaxWidth, webpCfg.Size.MaxHeight, webpCfg.Quality, outputClient}, nil
-}
+	converter := &WebpConverter{
+		size:           size,
+		quality:        webpCfg.Quality,
+		method:         webpCfg.Method,
+		alphaQuality:   webpCfg.AlphaQuality,
+		sharpYuv:       webpCfg.SharpYuv,
+		filterStrength: webpCfg.FilterStrength,
+		targetSize:     webpCfg.TargetSize,
+		qualitySearch:  qualitySearch,
+		keepAnimation:  webpCfg.Animation.Mode == "keep",
+		decodeOptions:  decodeOptions,
+		overlay:        overlay,
+		metadata:       metadata,
+		outputClient:   outputClient,
+	}
+
+	switch webpCfg.Mode {
+	case "", "lossy":
+	case "lossless":
+		converter.lossless = true
+	case "near-lossless":
+		converter.lossless = true
+		converter.nearLossless = webpCfg.NearLossless
+		if converter.nearLossless == nil {
+			defaultNearLossless := 60
+			converter.nearLossless = &defaultNearLossless
+		}
+	default:
+		return nil, fmt.Errorf("unsupported webp mode: %s", webpCfg.Mode)
+	}
+
+	switch webpCfg.Preset {
+	case "", "default":
+		converter.preset = encoder.PresetDefault
+	case "photo":
+		converter.preset = encoder.PresetPhoto
+	case "picture":
+		converter.preset = encoder.PresetPicture
+	case "drawing":
+		converter.preset = encoder.PresetDrawing
+	case "icon":
+		converter.preset = encoder.PresetIcon
+	case "text":
+		converter.preset = encoder.PresetText
+	default:
+		return nil, fmt.Errorf("unsupported webp preset: %s", webpCfg.Preset)
+	}
+
+	if _, err := converter.encoderOptions(converter.quality); err != nil {
+		return nil, err
+	}
 
-func (p *WebpConverter) Process(inputMetadata *input.MetadataStruct, reader io.Reader, outputName string) error {
-	var src image.Image
+	return converter, nil
+}
 
-	writer, err := p.outputClient.GetWriter(outputName, inputMetadata, "image/webp")
+// encoderOptions builds a fresh set of libwebp options for every call, as they hold a C config that is mutated on encoding.
+// In lossless modes the quality is the compression effort, same as in cwebp.
+func (p *WebpConverter) encoderOptions(quality int) (*encoder.Options, error) {
+	opts, err := encoder.NewLossyEncoderOptions(p.preset, float32(quality))
 	if err != nil {
-		return fmt.Errorf("fail to initialize writer for output: %w", err)
+		return nil, fmt.Errorf("create webp encoder options: %w", err)
 	}
-	defer writer.Close()
 
-	switch inputMetadata.ContentType {
-	case "image/jpeg":
-		src, err = jpeg.Decode(reader)
+	opts.Lossless = p.lossless
+	if p.nearLossless != nil {
+		opts.NearLossless = *p.nearLossless
+	}
+	if p.method != nil {
+		opts.Method = *p.method
+	}
+	if p.filterStrength != nil {
+		opts.FilterStrength = *p.filterStrength
+	}
+	opts.UseSharpYuv = p.sharpYuv
+	opts.TargetSize = p.targetSize
+
+	return opts, nil
+}
+
+func (p *WebpConverter) Process(source *Source, outputName string) error {
+	inputMetadata := source.Metadata
+	var encode func(quality int) ([]byte, error)
+	var score func(data []byte) (float64, error)
+	if p.keepAnimation {
+		anim, meta, err := source.decodeAnimation(p.decodeOptions)
 		if err != nil {
-			return fmt.Errorf("decode jpeg: %w", err)
+			return err
 		}
-	case "image/png":
-		src, err = png.Decode(reader)
+		drawOverlay, err := p.overlay.drawer(inputMetadata, meta)
 		if err != nil {
-			return fmt.Errorf("decode png: %w", err)
+			return err
+		}
+		focus := p.size.animationFocus(anim.frames[0], focalPoint(inputMetadata, meta))
+		anim.mapFrames(func(frame image.Image) image.Image {
+			return p.prepare(p.size.resize(frame, focus), drawOverlay)
+		})
+		if len(anim.frames) > 1 {
+			meta = p.metadata.apply(meta)
+			bounds := anim.frames[0].Bounds()
+			encode = func(quality int) ([]byte, error) {
+				var buf, out bytes.Buffer
+				newOptions := func() (*encoder.Options, error) { return p.encoderOptions(quality) }
+				if err := encodeWebpAnimation(&buf, anim, newOptions); err != nil {
+					return nil, err
+				}
+				if err := writeWebpWithMetadata(&out, buf.Bytes(), meta, bounds.Dx(), bounds.Dy()); err != nil {
+					return nil, err
+				}
+				return out.Bytes(), nil
+			}
+		} else {
+			encode, score = p.stillEncoder(anim.frames[0], meta)
 		}
-	case "image/webp":
-		src, err = webp.Decode(reader, nil)
+	} else {
+		decoded, err := source.decode(p.decodeOptions)
 		if err != nil {
-			return fmt.Errorf("decode webp: %w", err)
+			return err
 		}
-	default:
-		return fmt.Errorf("unsupported content type: %s", inputMetadata.ContentType)
+		drawOverlay, err := p.overlay.drawer(inputMetadata, decoded.meta)
+		if err != nil {
+			return err
+		}
+		resized := p.size.resizeShared(decoded, focalPoint(inputMetadata, decoded.meta))
+		encode, score = p.stillEncoder(p.prepare(resized, drawOverlay), decoded.meta)
 	}
 
-	opts, err := encoder.NewLossyEncoderOptions(encoder.PresetDefault, float32(p.quality))
+	data, misc, err := p.qualitySearch.encode(outputName, p.quality, encode, score)
 	if err != nil {
-		return fmt.Errorf("create webp encoder options: %w", err)
+		return err
 	}
 
-	xCoef := 1.0
-	if p.maxWidth > 0 {
-		xCoef = float64(p.maxWidth) / float64(src.Bounds().Max.X)
-	}
-	yCoef := 1.0
-	if p.maxHeight > 0 {
-		yCoef = float64(p.maxHeight) / float64(src.Bounds().Max.Y)
+	writer, err := p.outputClient.GetWriter(outputName, inputMetadata, "image/webp", misc)
+	if err != nil {
+		return fmt.Errorf("fail to initialize writer for output: %w", err)
 	}
-	slog.Debug("calculated coefficients", slog.Float64("x_coef", xCoef), slog.Float64("y_coef", yCoef))
+	defer writer.Close()
 
-	minCoef := xCoef
-	if yCoef < minCoef {
-		minCoef = yCoef
-	}
+	_, err = writer.Write(data)
+	return err
+}
 
-	if minCoef >= 1.0 {
-		return webp.Encode(writer, src, opts)
+// stillEncoder returns functions encoding dst with the given quality, along with its metadata,
+// and scoring such an encoding against dst.
+func (p *WebpConverter) stillEncoder(dst image.Image, meta *imageMetadata) (func(quality int) ([]byte, error), func(data []byte) (float64, error)) {
+	meta = p.metadata.apply(meta)
+	encode := func(quality int) ([]byte, error) {
+		opts, err := p.encoderOptions(quality)
+		if err != nil {
+			return nil, err
+		}
+		var buf, out bytes.Buffer
+		if err := webp.Encode(&buf, dst, opts); err != nil {
+			return nil, err
+		}
+		if err := writeWebpWithMetadata(&out, buf.Bytes(), meta, dst.Bounds().Dx(), dst.Bounds().Dy()); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
 	}
+	score := func(data []byte) (float64, error) {
+		decoded, err := webp.Decode(bytes.NewReader(data), nil)
+		if err != nil {
+			return 0, fmt.Errorf("decode webp for scoring: %w", err)
+		}
+		return ssim(dst, decoded), nil
+	}
+	return encode, score
+}
 
-	dst := image.NewRGBA(image.Rect(0, 0, int(float64(src.Bounds().Max.X)*minCoef+0.5), int(float64(src.Bounds().Max.Y)*minCoef+0.5)))
-	draw.CatmullRom.Scale(dst, dst.Rect, src, src.Bounds(), draw.Over, nil)
-
-	return webp.Encode(writer, dst, opts)
+// prepare draws the overlay over a resized image and quantizes its alpha.
+func (p *WebpConverter) prepare(resized image.Image, drawOverlay func(image.Image) image.Image) image.Image {
+	dst := drawOverlay(resized)
+	if p.alphaQuality != nil && !p.lossless {
+		dst = quantizeAlpha(dst, *p.alphaQuality)
+	}
+	return dst
 }
 
 func (p *WebpConverter) DeductOutputPath(inputPath string) string {
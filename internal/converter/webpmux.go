@@ -0,0 +1,325 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"golang.org/x/image/draw"
+
+	"github.com/kolesa-team/go-webp/encoder"
+	"github.com/kolesa-team/go-webp/webp"
+)
+
+// go-webp only wraps the simple libwebp API, so the extended WebP container (animation and metadata chunks)
+// is read and written here, passing the individual VP8/VP8L bitstreams to libwebp.
+
+const (
+	vp8xFlagAnimation = 0x02
+	vp8xFlagXMP       = 0x04
+	vp8xFlagEXIF      = 0x08
+	vp8xFlagAlpha     = 0x10
+	vp8xFlagICC       = 0x20
+
+	anmfFlagNoBlend           = 0x02
+	anmfFlagDisposeBackground = 0x01
+)
+
+type riffChunk struct {
+	fourCC string
+	data   []byte
+}
+
+func readUint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+// parseRiffChunks splits chunk data into chunks, without descending into nested ones.
+func parseRiffChunks(data []byte) ([]riffChunk, error) {
+	chunks := []riffChunk{}
+	for len(data) >= 8 {
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size > len(data)-8 {
+			return nil, fmt.Errorf("chunk %q is truncated", data[0:4])
+		}
+		chunks = append(chunks, riffChunk{string(data[0:4]), data[8 : 8+size]})
+		data = data[8+size:]
+		if size%2 == 1 && len(data) > 0 {
+			data = data[1:]
+		}
+	}
+	return chunks, nil
+}
+
+// parseWebpContainer returns the top-level chunks of a WebP file.
+func parseWebpContainer(data []byte) ([]riffChunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a webp file")
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:8]))
+	if size < 4 || size > len(data)-8 {
+		return nil, fmt.Errorf("webp file is truncated")
+	}
+	return parseRiffChunks(data[12 : 8+size])
+}
+
+func appendRiffChunk(buf []byte, chunk riffChunk) []byte {
+	buf = append(buf, chunk.fourCC...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(chunk.data)))
+	buf = append(buf, chunk.data...)
+	if len(chunk.data)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// writeWebpContainer writes chunks as a complete WebP file.
+func writeWebpContainer(w io.Writer, chunks []riffChunk) error {
+	body := []byte("WEBP")
+	for _, chunk := range chunks {
+		body = appendRiffChunk(body, chunk)
+	}
+
+	header := make([]byte, 8)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(body)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+func vp8xChunk(flags byte, width, height int) riffChunk {
+	data := make([]byte, 10)
+	data[0] = flags
+	putUint24(data[4:7], width-1)
+	putUint24(data[7:10], height-1)
+	return riffChunk{"VP8X", data}
+}
+
+// isAnimatedWebp tells if the data is an extended WebP file with the animation flag set.
+func isAnimatedWebp(data []byte) bool {
+	chunks, err := parseWebpContainer(data)
+	if err != nil || len(chunks) == 0 {
+		return false
+	}
+	return chunks[0].fourCC == "VP8X" && len(chunks[0].data) >= 10 && chunks[0].data[0]&vp8xFlagAnimation != 0
+}
+
+// webpFrameCount counts the frames of an animated WebP, which is 0 for still ones.
+func webpFrameCount(data []byte) (int, error) {
+	if !isAnimatedWebp(data) {
+		return 0, nil
+	}
+	chunks, err := parseWebpContainer(data)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, chunk := range chunks {
+		if chunk.fourCC == "ANMF" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// decodeWebpAnimation composes the frames of an animated WebP onto its canvas,
+// stopping after the frame with index lastFrame (or at the end, if lastFrame is negative).
+// With lastFrame set, only the frame it stopped at is kept.
+func decodeWebpAnimation(data []byte, lastFrame int) (*animation, error) {
+	chunks, err := parseWebpContainer(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].fourCC != "VP8X" || len(chunks[0].data) < 10 {
+		return nil, fmt.Errorf("webp file has no extended header")
+	}
+
+	canvasWidth := readUint24(chunks[0].data[4:7]) + 1
+	canvasHeight := readUint24(chunks[0].data[7:10]) + 1
+	canvas := image.NewNRGBA(image.Rect(0, 0, canvasWidth, canvasHeight))
+	anim := &animation{}
+
+	frameCount, lastDuration := 0, 0
+	var disposeRect image.Rectangle
+	for _, chunk := range chunks {
+		switch chunk.fourCC {
+		case "ANIM":
+			if len(chunk.data) < 6 {
+				return nil, fmt.Errorf("webp ANIM chunk is truncated")
+			}
+			anim.loopCount = int(binary.LittleEndian.Uint16(chunk.data[4:6]))
+		case "ANMF":
+			if len(chunk.data) < 16 {
+				return nil, fmt.Errorf("webp ANMF chunk is truncated")
+			}
+			x := readUint24(chunk.data[0:3]) * 2
+			y := readUint24(chunk.data[3:6]) * 2
+			width := readUint24(chunk.data[6:9]) + 1
+			height := readUint24(chunk.data[9:12]) + 1
+			duration := readUint24(chunk.data[12:15])
+			flags := chunk.data[15]
+
+			// frames have to lie within the canvas, which is checked against the limits before decoding
+			frameRect := image.Rect(x, y, x+width, y+height)
+			if !frameRect.In(canvas.Rect) {
+				return nil, fmt.Errorf("frame %d of %v lies outside the canvas", frameCount, frameRect)
+			}
+			frame, err := decodeWebpFrame(chunk.data[16:], width, height)
+			if err != nil {
+				return nil, fmt.Errorf("decode frame %d: %w", frameCount, err)
+			}
+
+			if !disposeRect.Empty() {
+				draw.Draw(canvas, disposeRect, image.Transparent, image.Point{}, draw.Src)
+			}
+
+			op := draw.Over
+			if flags&anmfFlagNoBlend != 0 {
+				op = draw.Src
+			}
+			draw.Draw(canvas, frameRect, frame, frame.Bounds().Min, op)
+
+			disposeRect = image.Rectangle{}
+			if flags&anmfFlagDisposeBackground != 0 {
+				disposeRect = frameRect
+			}
+
+			frameCount++
+			lastDuration = duration
+			if lastFrame < 0 || frameCount > lastFrame {
+				anim.frames = append(anim.frames, cloneNRGBA(canvas))
+				anim.delays = append(anim.delays, duration)
+			}
+			if lastFrame >= 0 && frameCount > lastFrame {
+				return anim, nil
+			}
+		}
+	}
+
+	if frameCount == 0 {
+		return nil, fmt.Errorf("animated webp has no frames")
+	}
+	// short animations fall back to their last frame, which the canvas still holds
+	if len(anim.frames) == 0 {
+		anim.frames = append(anim.frames, cloneNRGBA(canvas))
+		anim.delays = append(anim.delays, lastDuration)
+	}
+
+	return anim, nil
+}
+
+// decodeWebpFrame wraps the bitstream chunks of an ANMF frame into a standalone WebP and decodes it with libwebp.
+func decodeWebpFrame(frameData []byte, width, height int) (image.Image, error) {
+	chunks, err := parseRiffChunks(frameData)
+	if err != nil {
+		return nil, err
+	}
+
+	var alpha, bitstream *riffChunk
+	for i := range chunks {
+		switch chunks[i].fourCC {
+		case "ALPH":
+			alpha = &chunks[i]
+		case "VP8 ", "VP8L":
+			bitstream = &chunks[i]
+		}
+	}
+	if bitstream == nil {
+		return nil, fmt.Errorf("frame has no image data")
+	}
+
+	frameChunks := []riffChunk{*bitstream}
+	if alpha != nil && bitstream.fourCC == "VP8 " {
+		frameChunks = []riffChunk{vp8xChunk(vp8xFlagAlpha, width, height), *alpha, *bitstream}
+	}
+
+	var buf bytes.Buffer
+	if err := writeWebpContainer(&buf, frameChunks); err != nil {
+		return nil, err
+	}
+
+	// the bitstream decides how much libwebp allocates, so it has to match the size declared by the frame
+	cfg, err := webp.DecodeConfig(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width != width || cfg.Height != height {
+		return nil, fmt.Errorf("frame bitstream of %dx%d pixels doesn't match the declared %dx%d", cfg.Width, cfg.Height, width, height)
+	}
+	return webp.Decode(&buf, nil)
+}
+
+// encodeWebpAnimation encodes every frame with libwebp and muxes them into an animated WebP.
+// Frames always cover the whole canvas, so they are written without blending or disposal.
+func encodeWebpAnimation(w io.Writer, anim *animation, newOptions func() (*encoder.Options, error)) error {
+	bounds := anim.frames[0].Bounds()
+	flags := byte(vp8xFlagAnimation)
+
+	animData := make([]byte, 6)
+	binary.LittleEndian.PutUint16(animData[4:6], uint16(anim.loopCount))
+	chunks := []riffChunk{{}, {"ANIM", animData}}
+
+	for i, frame := range anim.frames {
+		opts, err := newOptions()
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, frame, opts); err != nil {
+			return fmt.Errorf("encode frame %d: %w", i, err)
+		}
+		frameChunks, err := parseWebpContainer(buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("parse encoded frame %d: %w", i, err)
+		}
+
+		frameData := make([]byte, 16)
+		putUint24(frameData[6:9], bounds.Dx()-1)
+		putUint24(frameData[9:12], bounds.Dy()-1)
+		putUint24(frameData[12:15], anim.delays[i])
+		frameData[15] = anmfFlagNoBlend
+		for _, chunk := range frameChunks {
+			switch chunk.fourCC {
+			case "ALPH":
+				flags |= vp8xFlagAlpha
+				frameData = appendRiffChunk(frameData, chunk)
+			case "VP8L":
+				if !isOpaque(frame) {
+					flags |= vp8xFlagAlpha
+				}
+				frameData = appendRiffChunk(frameData, chunk)
+			case "VP8 ":
+				frameData = appendRiffChunk(frameData, chunk)
+			}
+		}
+		chunks = append(chunks, riffChunk{"ANMF", frameData})
+	}
+
+	chunks[0] = vp8xChunk(flags, bounds.Dx(), bounds.Dy())
+	return writeWebpContainer(w, chunks)
+}
+
+func isOpaque(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return opaque.Opaque()
+	}
+	return false
+}
+
+func cloneNRGBA(src *image.NRGBA) *image.NRGBA {
+	dst := image.NewNRGBA(src.Rect)
+	copy(dst.Pix, src.Pix)
+	return dst
+}
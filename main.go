@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"flag"
@@ -32,11 +31,21 @@ func main() {
 
 	flag.Parse()
 
+	command := flag.Arg(0)
+	if command != "" && command != "duplicates" {
+		slog.Error("unknown command", slog.String("command", command))
+		os.Exit(1)
+	}
+
 	cfg := &config.Config{}
 	if err := config.LoadConfig(*configPath, cfg); err != nil {
 		slog.Error("fail to load configuration", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+	if command == "duplicates" && cfg.Duplicates == nil {
+		slog.Error("duplicates report needs the Duplicates section of the configuration")
+		os.Exit(1)
+	}
 
 	slog.SetLogLoggerLevel(cfg.LogLevel)
 	slog.Info("starting thumbnail generator...")
@@ -68,6 +77,18 @@ func main() {
 		converterTypes = append(converterTypes, converterCfg.Type)
 		converterHashes = append(converterHashes, crc32.ChecksumIEEE(converterBytes))
 	}
+	// larger outputs go first, so smaller ones can be scaled from them instead of from the full resolution
+	converterOrder := converter.SizeOrder(cfg.Converters)
+
+	contactSheets := make([]*converter.ContactSheet, 0, len(cfg.ContactSheets))
+	for i := range cfg.ContactSheets {
+		sheet, err := converter.NewContactSheet(&cfg.ContactSheets[i])
+		if err != nil {
+			slog.Error("fail to initialize contact sheet", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		contactSheets = append(contactSheets, sheet)
+	}
 
 	generalLogger := slog.With(slog.String("input_storage", cfg.Input.Storage.Type))
 	generalLogger.Info("initialized input client and converters", slog.String("converter_types", strings.Join(converterTypes, " ")))
@@ -87,6 +108,23 @@ func main() {
 	fileCount := len(files)
 	generalLogger.Info("scanned files", slog.Int("file_count", fileCount))
 
+	var hashCache *perceptualHashCache
+	if cfg.Duplicates != nil {
+		if hashCache, err = loadPerceptualHashCache(cfg.Duplicates.HashCsvPath); err != nil {
+			generalLogger.Error("fail to initialize perceptual hash cache", slog.String("cache_path", cfg.Duplicates.HashCsvPath), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	if command == "duplicates" {
+		if err := reportDuplicates(cfg, inputClient, files, hashCache, generalLogger); err != nil {
+			generalLogger.Error("fail to report duplicates", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		savePerceptualHashCache(cfg, hashCache, generalLogger)
+		os.Exit(0)
+	}
+
 	select {
 	case <-sigTermChan:
 		generalLogger.Info("exiting due to termination signal")
@@ -165,6 +203,17 @@ func main() {
 			var inputMetadata *input.MetadataStruct
 
 			id := inputClient.ID(file)
+			// a changed focus sidecar changes the outputs but not the input, so its hash is part of the cache key
+			if cfg.Input.CacheProcessed && cfg.Input.FocusSidecarSuffix != "" {
+				var err error
+				if inputMetadata, err = readInputMetadata(cfg, inputClient, inputName, fileLogger); err != nil {
+					fileLogger.Warn("fail to read metadata of (supposedly existing) input file", slog.String("error", err.Error()))
+					return
+				}
+				if inputMetadata.FocusHash != "" {
+					id += "+focus-" + inputMetadata.FocusHash
+				}
+			}
 			if _, ok := cacheMap[id]; !ok {
 				cacheMapMutex.Lock()
 				cacheMap[id] = make(map[uint32]struct{})
@@ -172,7 +221,8 @@ func main() {
 			}
 
 			convertersToLaunch := []int{}
-			for j, conv := range converters {
+			for _, j := range converterOrder {
+				conv := converters[j]
 				if cfg.Input.CacheProcessed {
 					cacheMapMutex.RLock()
 					if _, ok := cacheMap[id][converterHashes[j]]; ok {
@@ -190,7 +240,7 @@ func main() {
 				convLogger := fileLogger.With(slog.String("output_path", outputName), slog.Int("conv_index", j))
 
 				if inputMetadata == nil {
-					inputMetadata, err = inputClient.ReadMetadata(inputName)
+					inputMetadata, err = readInputMetadata(cfg, inputClient, inputName, fileLogger)
 					if err != nil {
 						fileLogger.Warn("fail to read metadata of (supposedly existing) input file", slog.String("error", err.Error()))
 						return
@@ -211,7 +261,7 @@ func main() {
 							continue
 						}
 						originalInputHash = outputMetadata.HashOriginal
-						if inputMetadata.Hash == originalInputHash {
+						if inputMetadata.Hash == originalInputHash && inputMetadata.FocusHash == outputMetadata.FocusHashOriginal {
 							convLogger.Info("skip already processed file (based on equal hash)", slog.String("input_hash", inputMetadata.Hash))
 							cacheMapMutex.Lock()
 							cacheMap[id][converterHashes[j]] = struct{}{}
@@ -226,6 +276,27 @@ func main() {
 			}
 
 			if len(convertersToLaunch) == 0 {
+				// inputs with nothing to convert are hashed too, so the cache covers every scanned input
+				if hashCache == nil {
+					return
+				}
+				if inputMetadata == nil {
+					var err error
+					if inputMetadata, err = readInputMetadata(cfg, inputClient, inputName, fileLogger); err != nil {
+						fileLogger.Warn("fail to read metadata of (supposedly existing) input file", slog.String("error", err.Error()))
+						return
+					}
+				}
+				processSemaphore <- struct{}{}
+				defer func() { <-processSemaphore }()
+				if _, err := hashCache.hashFile(cfg, inputClient, inputName, inputMetadata); err != nil {
+					fileLogger.Warn("fail to compute perceptual hash of input file", slog.String("error", err.Error()))
+				}
+				return
+			}
+
+			if err := converter.CheckFileSize(cfg.Input.Limits, inputMetadata.Size); err != nil {
+				fileLogger.Warn("skip input file over the limits", slog.String("error", err.Error()))
 				return
 			}
 
@@ -250,12 +321,21 @@ func main() {
 			}
 			reader.Close()
 
+			// the input is decoded once for all converters with the same decode options
+			source := converter.NewSource(inputMetadata, fileContent, cfg.Input.Limits)
+
+			if hashCache != nil {
+				if _, err := hashCache.hashInput(cfg.Duplicates, inputName, source); err != nil {
+					fileLogger.Warn("fail to compute perceptual hash of input file", slog.String("error", err.Error()))
+				}
+			}
+
 			for _, convIndex := range convertersToLaunch {
 				conv := converters[convIndex]
 				outputName := conv.DeductOutputPath(inputName)
 				convLogger := fileLogger.With(slog.String("output_path", outputName), slog.Int("conv_index", convIndex))
 
-				if err := conv.Process(inputMetadata, bytes.NewReader(fileContent), outputName); err != nil {
+				if err := conv.Process(source, outputName); err != nil {
 					convLogger.Warn("fail to convert file", slog.String("error", err.Error()))
 					return
 				}
@@ -277,8 +357,15 @@ func main() {
 	default:
 	}
 
+	if len(contactSheets) > 0 {
+		generalLogger.Info("rendering contact sheets")
+		renderContactSheets(cfg, inputClient, contactSheets, files, generalLogger)
+	}
+
 	generalLogger.Info("all files processed successfully")
 
+	savePerceptualHashCache(cfg, hashCache, generalLogger)
+
 	if cfg.Input.CacheProcessed {
 		generalLogger.Info("writing cache file")
 		cacheFile, err := os.OpenFile(cfg.Input.CacheProcessedCsvPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
@@ -300,3 +387,17 @@ func main() {
 
 	os.Exit(0)
 }
+
+// readInputMetadata reads the metadata of an input along with its focus sidecar, if those are enabled.
+func readInputMetadata(cfg *config.Config, inputClient input.InputClient, inputName string, logger *slog.Logger) (*input.MetadataStruct, error) {
+	inputMetadata, err := inputClient.ReadMetadata(inputName)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Input.FocusSidecarSuffix != "" {
+		if err := input.ReadFocusSidecar(inputClient, inputName, cfg.Input.FocusSidecarSuffix, inputMetadata); err != nil {
+			logger.Warn("fail to read focus sidecar of input file", slog.String("error", err.Error()))
+		}
+	}
+	return inputMetadata, nil
+}